@@ -0,0 +1,243 @@
+package setddblock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Backend is the lock storage used by DynamoDBLocker.
+// The default backend stores locks in DynamoDB and is registered for the ddb and dynamodb URL schemes.
+// Alternative stores can be plugged in with Register or WithBackend.
+type Backend interface {
+	// LockTableExists reports whether the lock table exists and can be used.
+	LockTableExists(ctx context.Context, tableName string) (bool, error)
+	// CreateLockTable creates the lock table and waits until it can be used.
+	CreateLockTable(ctx context.Context, tableName string) error
+	// AcquireLock tries to acquire the lock described by parms and atomically increments the fencing token of the lock item.
+	// If PrevRevision is set, the lock is taken over only when the stored revision is still PrevRevision.
+	// Unless IgnoreExpiry is set, a lock whose lease has expired is taken over as well.
+	// When the lock is held by someone else, LockGranted of the result is false and Revision is the holder's revision.
+	AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	// SendHeartbeat extends the lease of a held lock. PrevRevision must be the current revision.
+	SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	// ReleaseLock releases a held lock. PrevRevision must be the current revision.
+	// The fencing token of the lock item must be kept after the release.
+	ReleaseLock(ctx context.Context, parms *LockInput) error
+	// GetLockDetails returns the stored state of the lock item.
+	// If no one holds the lock, the error wraps ErrNotLocked.
+	GetLockDetails(ctx context.Context, tableName, itemID string) (*LockDetails, error)
+}
+
+// BackendFactory creates a Backend from the options passed to New.
+type BackendFactory func(opts *Options) (Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+// Register makes a Backend available for the given URL scheme.
+// If Register is called twice with the same scheme or if factory is nil, it panics.
+func Register(scheme string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		panic("setddblock: Register backend factory is nil")
+	}
+	if _, dup := backends[scheme]; dup {
+		panic("setddblock: Register called twice for scheme " + scheme)
+	}
+	backends[scheme] = factory
+}
+
+// Backends returns a sorted list of the registered URL schemes.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	list := make([]string, 0, len(backends))
+	for scheme := range backends {
+		list = append(list, scheme)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func openBackend(scheme string, opts *Options) (Backend, error) {
+	if opts.Backend != nil {
+		return opts.Backend, nil
+	}
+	backendsMu.RLock()
+	factory, ok := backends[scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown scheme %q, registered schemes are %v", scheme, Backends())
+	}
+	return factory(opts)
+}
+
+// LockInput is the parameter of the Backend lock operations.
+type LockInput struct {
+	TableName     string
+	ItemID        string
+	Revision      string
+	PrevRevision  *string
+	LeaseDuration time.Duration
+	// Owner is recorded on the lock item when the lock is acquired.
+	Owner Owner
+	// Payload is stored on the lock item by acquisitions and heartbeats if it is not nil.
+	// An acquisition without Payload removes the payload of the previous holder.
+	Payload []byte
+	// ClearPayload makes a heartbeat remove the payload stored on the lock item, when Payload is nil.
+	ClearPayload bool
+	// IgnoreExpiry tells the backend not to trust the stored expiry of the holder's lease, which was computed by the clock of another host.
+	// The lease is then taken over only through PrevRevision, after the waiter has seen it unchanged for a full lease duration.
+	IgnoreExpiry bool
+	// Wait tells the backend that the caller retries the acquisition until it is granted, see WithDelay.
+	// A writer of RWBackend that is not granted blocks new readers only if it waits.
+	Wait bool
+	// RequireHeld makes SendHeartbeat fail with ErrLockLost unless the item is still held by PrevRevision.
+	// Without it, a heartbeat writes the lock item again if it has been removed, e.g. by the TTL after WithReleasedItemRetention.
+	RequireHeld bool
+}
+
+func (parms *LockInput) String() string {
+	prevRevision := "<nil>"
+	if parms.PrevRevision != nil {
+		prevRevision = *parms.PrevRevision
+	}
+	return fmt.Sprintf(
+		"item_id=%s, lease_duration=%s, revision=%s, prev_revision=%s",
+		parms.ItemID,
+		parms.LeaseDuration,
+		parms.Revision,
+		prevRevision,
+	)
+}
+
+// LockOutput is the result of the Backend lock operations.
+type LockOutput struct {
+	LockGranted        bool
+	LeaseDuration      time.Duration
+	NextHeartbeatLimit time.Time
+	Revision           string
+	// FencingToken is incremented by every acquisition of the lock item and kept by heartbeats.
+	FencingToken int64
+}
+
+func (output *LockOutput) String() string {
+	return fmt.Sprintf(
+		"lock_granted=%v, lease_duration=%s, revision=%s, fencing_token=%d, next_heartbeat_limit=%s",
+		output.LockGranted,
+		output.LeaseDuration,
+		output.Revision,
+		output.FencingToken,
+		output.NextHeartbeatLimit,
+	)
+}
+
+// LockDetails is the stored state of a lock item.
+type LockDetails struct {
+	ItemID string
+	// TTL is the unix time in seconds after which the item may be removed by the garbage collection of the storage.
+	// It is 0 for a held item, which is never removed.
+	TTL int64
+	// ExpirationTime is the end of the lease in millisecond precision, after which the lock can be taken over.
+	ExpirationTime time.Time
+	Revision       string
+	FencingToken   int64
+	Owner          Owner
+	AcquiredAt     time.Time
+	Payload        []byte
+}
+
+// Expired reports whether the lease has run out, i.e. the holder has stopped sending heartbeats and the lock can be taken over.
+func (d *LockDetails) Expired() bool {
+	return !d.ExpirationTime.IsZero() && time.Now().After(d.ExpirationTime)
+}
+
+// UnmarshalPayload parses the JSON-encoded payload and stores the result in the value pointed to by v.
+func (d *LockDetails) UnmarshalPayload(v interface{}) error {
+	return json.Unmarshal(d.Payload, v)
+}
+
+// Owner identifies the process holding a lock.
+type Owner struct {
+	// Name is the owner name given by WithOwnerName.
+	Name     string
+	Hostname string
+	PID      int
+	// ProcessStartTime is the time when the owner process loaded this package, not the start time of the process.
+	// With Hostname and PID, it tells apart the processes that have reused a PID.
+	ProcessStartTime time.Time
+}
+
+// loadedAt is the time when this package was loaded, which is recorded as Owner.ProcessStartTime.
+var loadedAt = time.Now()
+
+func currentOwner(name string) Owner {
+	hostname, _ := os.Hostname()
+	return Owner{
+		Name:             name,
+		Hostname:         hostname,
+		PID:              os.Getpid(),
+		ProcessStartTime: loadedAt,
+	}
+}
+
+func (o Owner) String() string {
+	if o.Name == "" {
+		return fmt.Sprintf("%s[%d]", o.Hostname, o.PID)
+	}
+	return fmt.Sprintf("%s (%s[%d])", o.Name, o.Hostname, o.PID)
+}
+
+// RWBackend is implemented by backends that support the shared/exclusive locks of DynamoDBRWLocker.
+// A reader lease is identified by its Revision, and the write lock uses SendHeartbeat and ReleaseLock of Backend.
+type RWBackend interface {
+	Backend
+	// AcquireReadLock adds a reader lease unless a writer holds the lock or is waiting for it.
+	// If PrevRevision is set, a writer still holding PrevRevision is considered expired.
+	AcquireReadLock(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	// SendReadHeartbeat extends the reader lease PrevRevision and renames it to Revision.
+	SendReadHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	// ReleaseReadLock removes the reader lease PrevRevision.
+	ReleaseReadLock(ctx context.Context, parms *LockInput) error
+	// AcquireWriteLock acquires the write lock when there is no reader lease.
+	// While readers hold the lock, it records that a writer is waiting, so that no new reader is admitted.
+	AcquireWriteLock(ctx context.Context, parms *LockInput) (*LockOutput, error)
+}
+
+// SemaphoreBackend is implemented by backends that support the counting semaphore of DynamoDBSemaphore.
+// A permit holder is identified by the Revision of its lease.
+type SemaphoreBackend interface {
+	Backend
+	// AcquirePermit adds a holder lease if fewer than permits live holders hold the item,
+	// and atomically increments the fencing token of the item. Expired holder leases are reclaimed.
+	AcquirePermit(ctx context.Context, parms *LockInput, permits int) (*LockOutput, error)
+	// SendPermitHeartbeat extends the holder lease PrevRevision and renames it to Revision.
+	SendPermitHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	// ReleasePermit removes the holder lease PrevRevision.
+	ReleasePermit(ctx context.Context, parms *LockInput) error
+}
+
+// MultiLockBackend is implemented by backends that support the all-or-nothing locks of DynamoDBMultiLocker.
+// Each method handles a set of items in the same table as a single atomic operation.
+// The outputs are in the order of parms.
+type MultiLockBackend interface {
+	Backend
+	// AcquireLocks acquires all of the items, or none of them.
+	// An item is acquired if it is free, its lease has expired and IgnoreExpiry is not set, or it is still held by its PrevRevision.
+	// If any item is held by someone else, no item is written and every output has LockGranted false,
+	// with the Revision of the current holder, which is empty for a free item.
+	AcquireLocks(ctx context.Context, parms []*LockInput) ([]*LockOutput, error)
+	// SendHeartbeats extends the leases of all of the items.
+	// If any item is no longer held by its PrevRevision, no lease is extended and the error wraps ErrLockLost.
+	SendHeartbeats(ctx context.Context, parms []*LockInput) ([]*LockOutput, error)
+	// ReleaseLocks releases all of the items still held by their PrevRevision.
+	ReleaseLocks(ctx context.Context, parms []*LockInput) error
+}
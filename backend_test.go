@@ -0,0 +1,31 @@
+package setddblock_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mashiike/setddblock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendRegistry(t *testing.T) {
+	require.Contains(t, setddblock.Backends(), "ddb")
+	require.Contains(t, setddblock.Backends(), "dynamodb")
+
+	errFactory := errors.New("factory called")
+	setddblock.Register("registry-test", func(opts *setddblock.Options) (setddblock.Backend, error) {
+		return nil, errFactory
+	})
+	require.Contains(t, setddblock.Backends(), "registry-test")
+	_, err := setddblock.New("registry-test://test/item1")
+	require.ErrorIs(t, err, errFactory)
+
+	require.Panics(t, func() {
+		setddblock.Register("registry-test", func(opts *setddblock.Options) (setddblock.Backend, error) {
+			return nil, nil
+		})
+	})
+
+	_, err = setddblock.New("unknown://test/item1")
+	require.Error(t, err)
+}
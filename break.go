@@ -0,0 +1,59 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Breaker is implemented by backends that can release a lock on behalf of its holder.
+type Breaker interface {
+	Backend
+	// ForceRelease releases the lock only while it is still held by the lease of parms.FencingToken,
+	// otherwise the error wraps ErrFencingTokenMismatch, or ErrNotLocked if no one holds the lock.
+	// BrokenBy and Reason are recorded on the lock item, and the next heartbeat of the evicted holder
+	// fails with *LockBrokenError. The fencing token is kept, so the next holder gets a greater one.
+	ForceRelease(ctx context.Context, parms *ForceReleaseInput) error
+}
+
+// ForceReleaseInput is the parameter of ForceRelease of Breaker.
+type ForceReleaseInput struct {
+	TableName string
+	ItemID    string
+	// FencingToken is the fencing token of the lease to break, as read by GetLockDetails or ListLocks.
+	// Unlike the revision, it is kept by heartbeats.
+	FencingToken int64
+	BrokenBy     Owner
+	Reason       string
+}
+
+// ForceRelease releases the lock of a wedged holder, which is still held by the lease of expectedFencingToken,
+// as read by GetLockDetails or ListLocks. The fencing token is kept by heartbeats, so the lease is broken
+// however many heartbeats it has sent since, and the error wraps ErrFencingTokenMismatch once another lease holds the lock.
+// The owner of the Client, see WithOwnerName, is recorded as the breaker with the reason,
+// and the evicted holder loses the lease at its next heartbeat.
+func (c *Client) ForceRelease(ctx context.Context, tableName, itemID string, expectedFencingToken int64, reason string) error {
+	breaker, ok := c.svc.(Breaker)
+	if !ok {
+		return errors.New("backend does not support force release")
+	}
+	if expectedFencingToken <= 0 {
+		return errors.New("expected fencing token must be positive")
+	}
+	opts, err := c.options(nil)
+	if err != nil {
+		return err
+	}
+	err = breaker.ForceRelease(ctx, &ForceReleaseInput{
+		TableName:    tableName,
+		ItemID:       itemID,
+		FencingToken: expectedFencingToken,
+		BrokenBy:     currentOwner(opts.OwnerName),
+		Reason:       reason,
+	})
+	if err != nil {
+		return fmt.Errorf("force release failed: %w", err)
+	}
+	opts.Logger.Printf("[warn][setddblock] force released item_id=%s, table_name=%s, fencing_token=%d: %s", itemID, tableName, expectedFencingToken, reason)
+	return nil
+}
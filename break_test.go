@@ -0,0 +1,159 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForceRelease(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	client, err := setddblock.NewClient(
+		setddblock.WithBackend(backend),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(time.Minute),
+	)
+	require.NoError(t, err)
+	operator, err := setddblock.NewClient(
+		setddblock.WithBackend(backend),
+		setddblock.WithOwnerName("operator"),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	holder, err := client.Locker("test", "item1")
+	require.NoError(t, err)
+	lease, err := holder.Acquire(ctx)
+	require.NoError(t, err)
+
+	err = operator.ForceRelease(ctx, "test", "item1", lease.FencingToken()+1, "host is wedged")
+	require.ErrorIs(t, err, setddblock.ErrFencingTokenMismatch)
+
+	details, err := holder.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.NoError(t, lease.Refresh(ctx), "a heartbeat renews the revision but keeps the fencing token")
+	require.NoError(t, operator.ForceRelease(ctx, "test", "item1", details.FencingToken, "host is wedged"))
+	_, err = holder.GetLockDetails(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotLocked)
+	err = operator.ForceRelease(ctx, "test", "item1", details.FencingToken, "host is wedged")
+	require.ErrorIs(t, err, setddblock.ErrNotLocked)
+
+	err = lease.Refresh(ctx)
+	require.ErrorIs(t, err, setddblock.ErrLockLost)
+	var brokenErr *setddblock.LockBrokenError
+	require.ErrorAs(t, err, &brokenErr)
+	require.Equal(t, "operator", brokenErr.BrokenBy.Name)
+	require.Equal(t, "host is wedged", brokenErr.Reason)
+	require.False(t, brokenErr.BrokenAt.IsZero())
+	<-lease.Lost()
+	require.ErrorAs(t, lease.Err(), &brokenErr)
+
+	next, err := client.Locker("test", "item1")
+	require.NoError(t, err)
+	granted, err := next.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted, "the broken lock is free")
+	require.Greater(t, next.FencingToken(), lease.FencingToken())
+	require.NoError(t, next.UnlockWithErr(ctx))
+}
+
+// brokenStubDynamoDB fails every heartbeat, as if the lock had been broken by ForceRelease.
+type brokenStubDynamoDB struct {
+	stubDynamoDB
+	returnValues []types.ReturnValuesOnConditionCheckFailure
+}
+
+func (c *brokenStubDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if aws.ToString(params.ConditionExpression) != "attribute_not_exists(#ID) OR #Revision=:PrevRevision" {
+		return c.stubDynamoDB.UpdateItem(ctx, params, optFns...)
+	}
+	c.record("UpdateItem " + aws.ToString(params.ConditionExpression))
+	c.returnValues = append(c.returnValues, params.ReturnValuesOnConditionCheckFailure)
+	prevRevision := params.ExpressionAttributeValues[":PrevRevision"].(*types.AttributeValueMemberS).Value
+	return nil, &types.ConditionalCheckFailedException{
+		Message: aws.String("The conditional request failed"),
+		Item: map[string]types.AttributeValue{
+			"lock_id": &types.AttributeValueMemberS{Value: "item1"},
+			"Broken": &types.AttributeValueMemberM{
+				Value: map[string]types.AttributeValue{
+					"Revision":  &types.AttributeValueMemberS{Value: prevRevision},
+					"OwnerName": &types.AttributeValueMemberS{Value: "operator"},
+					"BrokenAt":  &types.AttributeValueMemberN{Value: "1700000000000"},
+					"Reason":    &types.AttributeValueMemberS{Value: "host is wedged"},
+				},
+			},
+		},
+	}
+}
+
+func TestHeartbeatDetectsForceRelease(t *testing.T) {
+	client := &brokenStubDynamoDB{}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithSchema(setddblock.Schema{PartitionKey: "lock_id"}),
+		setddblock.WithLeaseDuration(time.Minute),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	lease, err := locker.Acquire(ctx)
+	require.NoError(t, err)
+	err = lease.Refresh(ctx)
+	var brokenErr *setddblock.LockBrokenError
+	require.ErrorAs(t, err, &brokenErr)
+	require.Equal(t, "operator", brokenErr.BrokenBy.Name)
+	require.Equal(t, "host is wedged", brokenErr.Reason)
+	require.Equal(t, time.UnixMilli(1700000000000), brokenErr.BrokenAt)
+	<-lease.Lost()
+	require.Equal(t, []types.ReturnValuesOnConditionCheckFailure{types.ReturnValuesOnConditionCheckFailureAllOld}, client.returnValues)
+}
+
+// heartbeatingStubDynamoDB holds the lock with fencing token 7, and renews the revision as a heartbeat
+// between the read and the first force release.
+type heartbeatingStubDynamoDB struct {
+	stubDynamoDB
+	prevRevisions []string
+}
+
+func (c *heartbeatingStubDynamoDB) GetItem(_ context.Context, _ *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: heldStubItem("revision-1")}, nil
+}
+
+func (c *heartbeatingStubDynamoDB) UpdateItem(_ context.Context, params *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	prevRevision := params.ExpressionAttributeValues[":PrevRevision"].(*types.AttributeValueMemberS).Value
+	c.prevRevisions = append(c.prevRevisions, prevRevision)
+	if prevRevision != "revision-2" {
+		return nil, &types.ConditionalCheckFailedException{
+			Message: aws.String("The conditional request failed"),
+			Item:    heldStubItem("revision-2"),
+		}
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func heldStubItem(revision string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ID":           &types.AttributeValueMemberS{Value: "item1"},
+		"Revision":     &types.AttributeValueMemberS{Value: revision},
+		"FencingToken": &types.AttributeValueMemberN{Value: "7"},
+	}
+}
+
+func TestForceReleaseAcrossHeartbeats(t *testing.T) {
+	stub := &heartbeatingStubDynamoDB{}
+	client, err := setddblock.NewClient(setddblock.WithDynamoDBClient(stub))
+	require.NoError(t, err)
+	ctx := context.Background()
+	err = client.ForceRelease(ctx, "test", "item1", 8, "host is wedged")
+	require.ErrorIs(t, err, setddblock.ErrFencingTokenMismatch)
+	require.Empty(t, stub.prevRevisions)
+
+	require.NoError(t, client.ForceRelease(ctx, "test", "item1", 7, "host is wedged"))
+	require.Equal(t, []string{"revision-1", "revision-2"}, stub.prevRevisions, "the revision renewed by a heartbeat is read again")
+}
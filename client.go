@@ -0,0 +1,203 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Client hands out lockers that share one Backend, e.g. one DynamoDB client with its credentials and HTTP connection pool,
+// and the knowledge of which lock tables exist.
+// Build it once and use it for the lifetime of the process, instead of calling New for every item.
+// A Client is safe for concurrent use.
+type Client struct {
+	svc    Backend
+	optFns []func(*Options)
+	tables *lockTables
+}
+
+// NewClient returns *Client for the DynamoDB backend, or for the Backend given by WithBackend.
+// The options are the defaults of every locker handed out by the Client.
+// The options of the Backend, such as WithEndpoint, WithSchema and the table options, can only be given here.
+func NewClient(optFns ...func(*Options)) (*Client, error) {
+	opts, err := newOptionsWith(optFns)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := openBackend("ddb", opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		svc:    svc,
+		optFns: optFns,
+		tables: newLockTables(),
+	}, nil
+}
+
+// options returns the options of a locker, which are the options of the Client overridden by optFns.
+// The options of the Backend only take effect in NewClient, so optFns changing them is an error.
+func (c *Client) options(optFns []func(*Options)) (*Options, error) {
+	opts, err := newOptionsWith(append(append([]func(*Options){}, c.optFns...), optFns...))
+	if err != nil {
+		return nil, err
+	}
+	if len(optFns) > 0 {
+		base, err := newOptionsWith(c.optFns)
+		if err != nil {
+			return nil, err
+		}
+		if !sameInstance(base.Backend, opts.Backend) || !sameInstance(base.DynamoDBClient, opts.DynamoDBClient) ||
+			!reflect.DeepEqual(base.backendOptions(), opts.backendOptions()) {
+			return nil, errors.New("backend options such as WithBackend, WithEndpoint, WithRegion, WithAWSConfig, WithDynamoDBClient, WithSchema, the table options and WithReleasedItemRetention only take effect in NewClient")
+		}
+	}
+	opts.tables = c.tables
+	return opts, nil
+}
+
+// backendOptions are the options used to build the Backend, other than the Backend and the DynamoDB client.
+type backendOptions struct {
+	Endpoint              string
+	Region                string
+	AWSConfig             *aws.Config
+	Schema                *Schema
+	Table                 TableOptions
+	ValidateTable         bool
+	RepairTTL             bool
+	ReleasedItemRetention time.Duration
+}
+
+func (opts *Options) backendOptions() backendOptions {
+	return backendOptions{
+		Endpoint:              opts.Endpoint,
+		Region:                opts.Region,
+		AWSConfig:             opts.AWSConfig,
+		Schema:                opts.Schema,
+		Table:                 opts.Table,
+		ValidateTable:         opts.ValidateTable,
+		RepairTTL:             opts.RepairTTL,
+		ReleasedItemRetention: opts.ReleasedItemRetention,
+	}
+}
+
+// sameInstance reports whether a and b are the same instance, such as the same pointer to a Backend.
+// Values that can not be compared are compared deeply.
+func sameInstance(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if t := reflect.TypeOf(a); t != reflect.TypeOf(b) {
+		return false
+	} else if !t.Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
+// Locker returns *DynamoDBLocker for the item, like New with ddb://<table_name>/<item_id>.
+func (c *Client) Locker(tableName, itemID string, optFns ...func(*Options)) (*DynamoDBLocker, error) {
+	opts, err := c.options(optFns)
+	if err != nil {
+		return nil, err
+	}
+	return newLocker(tableName, itemID, c.svc, c.svc, opts), nil
+}
+
+// RWLocker returns *DynamoDBRWLocker for the item, like NewRW.
+func (c *Client) RWLocker(tableName, itemID string, optFns ...func(*Options)) (*DynamoDBRWLocker, error) {
+	opts, err := c.options(optFns)
+	if err != nil {
+		return nil, err
+	}
+	return newRW(tableName, itemID, c.svc, opts)
+}
+
+// Semaphore returns *DynamoDBSemaphore for the item, like NewSemaphore.
+func (c *Client) Semaphore(tableName, itemID string, permits int, optFns ...func(*Options)) (*DynamoDBSemaphore, error) {
+	opts, err := c.options(optFns)
+	if err != nil {
+		return nil, err
+	}
+	return newSemaphore(tableName, itemID, permits, c.svc, opts)
+}
+
+// MultiLocker returns *DynamoDBMultiLocker for the items, like NewMulti.
+func (c *Client) MultiLocker(tableName string, itemIDs []string, optFns ...func(*Options)) (*DynamoDBMultiLocker, error) {
+	opts, err := c.options(optFns)
+	if err != nil {
+		return nil, err
+	}
+	return newMulti(tableName, itemIDs, c.svc, opts)
+}
+
+// Election returns *Election on the item, like NewElection.
+func (c *Client) Election(tableName, itemID string, optFns ...func(*Options)) (*Election, error) {
+	locker, err := c.Locker(tableName, itemID, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return newElection(locker), nil
+}
+
+// lockTables remembers the lock tables known to exist, so that lockers sharing it check each table once.
+type lockTables struct {
+	mu     sync.Mutex
+	tables map[string]*lockTable
+}
+
+// lockTable is the state of one lock table. Only the lockers of the same table wait for each other.
+type lockTable struct {
+	// sem is held while the table is checked or created, and guards exists.
+	sem    chan struct{}
+	exists bool
+}
+
+func newLockTables() *lockTables {
+	return &lockTables{
+		tables: make(map[string]*lockTable),
+	}
+}
+
+// ensure creates the lock table unless it is known to exist.
+// If create is false, a missing table is an error wrapping ErrTableNotFound.
+func (t *lockTables) ensure(ctx context.Context, svc Backend, tableName string, create bool, logger Logger) error {
+	t.mu.Lock()
+	table, ok := t.tables[tableName]
+	if !ok {
+		table = &lockTable{
+			sem: make(chan struct{}, 1),
+		}
+		t.tables[tableName] = table
+	}
+	t.mu.Unlock()
+	select {
+	case table.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-table.sem }()
+	if table.exists {
+		return nil
+	}
+	exists, err := svc.LockTableExists(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	logger.Printf("[debug][setddblock] lock table exists = %v", exists)
+	if !exists {
+		if !create {
+			return fmt.Errorf("%w: %s", ErrTableNotFound, tableName)
+		}
+		if err := svc.CreateLockTable(ctx, tableName); err != nil {
+			return err
+		}
+	}
+	table.exists = true
+	return nil
+}
@@ -0,0 +1,132 @@
+package setddblock_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+type countingBackend struct {
+	*setddblocktest.Backend
+	tableChecks int32
+}
+
+func (b *countingBackend) LockTableExists(ctx context.Context, tableName string) (bool, error) {
+	atomic.AddInt32(&b.tableChecks, 1)
+	return b.Backend.LockTableExists(ctx, tableName)
+}
+
+func TestClient(t *testing.T) {
+	backend := &countingBackend{Backend: setddblocktest.NewBackend()}
+	client, err := setddblock.NewClient(
+		setddblock.WithBackend(backend),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, itemID := range []string{"item1", "item2", "item3"} {
+		locker, err := client.Locker("test", itemID)
+		require.NoError(t, err)
+		granted, err := locker.LockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted)
+		require.NoError(t, locker.UnlockWithErr(ctx))
+	}
+	require.EqualValues(t, 1, atomic.LoadInt32(&backend.tableChecks), "the table is checked once")
+
+	holder, err := client.Locker("test", "item1")
+	require.NoError(t, err)
+	granted, err := holder.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer holder.Unlock()
+	waiter, err := client.Locker("test", "item1", setddblock.WithDelay(true))
+	require.NoError(t, err)
+	waitCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	_, err = waiter.LockWithErr(waitCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "per-locker options override the client options")
+
+	_, err = client.Locker("test", "item1", setddblock.WithLeaseDuration(time.Hour))
+	require.Error(t, err)
+}
+
+// slowTableBackend blocks the check of the table "slow" until unblock is closed.
+type slowTableBackend struct {
+	*setddblocktest.Backend
+	unblock chan struct{}
+}
+
+func (b *slowTableBackend) LockTableExists(ctx context.Context, tableName string) (bool, error) {
+	if tableName == "slow" {
+		select {
+		case <-b.unblock:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	return b.Backend.LockTableExists(ctx, tableName)
+}
+
+func TestClientTablesDoNotBlockEachOther(t *testing.T) {
+	backend := &slowTableBackend{Backend: setddblocktest.NewBackend(), unblock: make(chan struct{})}
+	client, err := setddblock.NewClient(setddblock.WithBackend(backend), setddblock.WithDelay(false))
+	require.NoError(t, err)
+	ctx := context.Background()
+	slow, err := client.Locker("slow", "item1")
+	require.NoError(t, err)
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := slow.LockWithErr(ctx)
+		slowDone <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	fast, err := client.Locker("fast", "item1")
+	require.NoError(t, err)
+	fastCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	granted, err := fast.LockWithErr(fastCtx)
+	require.NoError(t, err, "the lockers of another table do not wait for the slow table")
+	require.True(t, granted)
+	require.NoError(t, fast.UnlockWithErr(ctx))
+
+	waiter, err := client.Locker("slow", "item2")
+	require.NoError(t, err)
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = waiter.LockWithErr(waitCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "a locker of the same table waits for the check within its context")
+
+	close(backend.unblock)
+	require.NoError(t, <-slowDone)
+	require.NoError(t, slow.UnlockWithErr(ctx))
+}
+
+func TestClientBackendOptions(t *testing.T) {
+	client, err := setddblock.NewClient(
+		setddblock.WithBackend(setddblocktest.NewBackend()),
+		setddblock.WithEndpoint("http://localhost:8000"),
+		setddblock.WithTableTags(map[string]string{"team": "platform"}),
+	)
+	require.NoError(t, err)
+	for _, optFn := range []func(*setddblock.Options){
+		setddblock.WithBackend(setddblocktest.NewBackend()),
+		setddblock.WithEndpoint("http://localhost:8001"),
+		setddblock.WithRegion("us-east-1"),
+		setddblock.WithSchema(setddblock.Schema{PartitionKey: "PK"}),
+		setddblock.WithPointInTimeRecovery(),
+		setddblock.WithReleasedItemRetention(time.Hour),
+	} {
+		_, err := client.Locker("test", "item1", optFn)
+		require.Error(t, err, "the options of the backend only take effect in NewClient")
+	}
+	_, err = client.Locker("test", "item1", setddblock.WithEndpoint("http://localhost:8000"), setddblock.WithLeaseDuration(time.Second))
+	require.NoError(t, err, "the same backend options and the locker options are accepted")
+}
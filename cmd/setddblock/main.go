@@ -27,8 +27,8 @@ func main() {
 
 func _main() int {
 	var (
-		n, N, x, X, debug, versionFlag bool
-		endpoint, region, timeout      string
+		n, N, x, X, debug, versionFlag   bool
+		endpoint, region, timeout, owner string
 	)
 	flag.CommandLine.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: setddblock [ -nNxX ] [--endpoint <endpoint>] [--debug --version] ddb://<table_name>/<item_id> your_command\n")
@@ -43,6 +43,7 @@ func _main() int {
 	flag.StringVar(&endpoint, "endpoint", "", "If you switch remote, set AWS DynamoDB endpoint url.")
 	flag.StringVar(&region, "region", "", "aws region")
 	flag.StringVar(&timeout, "timeout", "", "set command timeout (e.g., 30s, 1m, 2h)")
+	flag.StringVar(&owner, "owner", "", "owner name recorded on the lock item")
 
 	args := make([]string, 1, len(os.Args))
 	args[0] = os.Args[0]
@@ -117,6 +118,7 @@ func _main() int {
 		setddblock.WithDelay(delay),
 		setddblock.WithLogger(logger),
 		setddblock.WithRegion(region),
+		setddblock.WithOwnerName(owner),
 	}
 	if endpoint != "" {
 		optFns = append(optFns, setddblock.WithEndpoint(endpoint))
@@ -149,8 +151,10 @@ func _main() int {
 			logger.Println("[error][setddblock] failed to retrieve lock details:", err)
 			return 4
 		}
-		logger.Printf("[warn][setddblock] lock was not granted for item_id=%s. TTL: %d, Expires: %s, Revision: %s",
+		logger.Printf("[warn][setddblock] lock was not granted for item_id=%s. Held by %s since %s. TTL: %d, Expires: %s, Revision: %s",
 			locker.ItemID(),
+			lockDetails.Owner,
+			lockDetails.AcquiredAt.Format(time.RFC3339),
 			lockDetails.TTL,
 			lockDetails.ExpirationTime.Format(time.RFC3339),
 			lockDetails.Revision,
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,15 +17,32 @@ import (
 	retry "github.com/shogo82148/go-retry"
 )
 
-type dynamoDBService struct {
-	client *dynamodb.Client
-	logger Logger
+// DynamoDBAPI is the set of DynamoDB API calls used by the DynamoDB backend.
+// *dynamodb.Client satisfies it, and an instrumented client or a test double can be given by WithDynamoDBClient.
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	TransactGetItems(ctx context.Context, params *dynamodb.TransactGetItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
 }
 
-type LockDetails struct {
-	TTL            int64
-	ExpirationTime time.Time
-	Revision       string
+var _ DynamoDBAPI = (*dynamodb.Client)(nil)
+
+type dynamoDBService struct {
+	client        DynamoDBAPI
+	logger        Logger
+	table         TableOptions
+	schema        Schema
+	validateTable bool
+	repairTTL     bool
+	// releasedRetention is the TTL of a released lock item, 0 keeps it.
+	releasedRetention time.Duration
 }
 
 func (svc *dynamoDBService) GetLockDetails(ctx context.Context, tableName, itemID string) (*LockDetails, error) {
@@ -41,26 +59,94 @@ func (svc *dynamoDBService) GetLockDetails(ctx context.Context, tableName, itemI
 		return nil, err
 	}
 
-	ttl, ok := readAttributeValueMemberN(output.Item, "ttl")
-	if !ok {
-		return nil, errors.New("failed to read TTL")
+	revision, ok := readAttributeValueMemberS(output.Item, "Revision")
+	if !ok || revision == "" {
+		return nil, fmt.Errorf("%w: item_id=%s, table_name=%s", ErrNotLocked, itemID, tableName)
 	}
+	return readLockDetails(itemID, output.Item)
+}
 
-	revision, ok := readAttributeValueMemberS(output.Item, "Revision")
-	if !ok {
-		return nil, errors.New("failed to read Revision")
+// readLockDetails returns the lock details of a held lock item.
+func readLockDetails(itemID string, item map[string]types.AttributeValue) (*LockDetails, error) {
+	revision, _ := readAttributeValueMemberS(item, "Revision")
+	ttl, _ := readAttributeValueMemberN(item, "ttl")
+
+	fencingToken, _ := readAttributeValueMemberN(item, "FencingToken")
+	owner, acquiredAt := readOwner(item)
+	var payload []byte
+	if b, ok := item["Payload"].(*types.AttributeValueMemberB); ok {
+		payload = b.Value
 	}
 
-	expirationTime := time.Unix(ttl, 0)
+	expirationTime, _ := leaseExpiry(item)
 
 	return &LockDetails{
+		ItemID:         itemID,
 		TTL:            ttl,
 		ExpirationTime: expirationTime,
 		Revision:       revision,
+		FencingToken:   fencingToken,
+		Owner:          owner,
+		AcquiredAt:     acquiredAt,
+		Payload:        payload,
 	}, nil
 }
 
+func init() {
+	Register("ddb", newDynamoDBBackend)
+	Register("dynamodb", newDynamoDBBackend)
+}
+
+func newDynamoDBBackend(opts *Options) (Backend, error) {
+	svc, err := newDynamoDBService(opts)
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
 func newDynamoDBService(opts *Options) (*dynamoDBService, error) {
+	client, err := newDynamoDBClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	schema := DefaultSchema()
+	if opts.Schema != nil {
+		schema = opts.Schema.withDefaults()
+		if err := schema.validate(); err != nil {
+			return nil, err
+		}
+		if schema != DefaultSchema() {
+			client = newSchemaClient(client, schema)
+		}
+	}
+	return &dynamoDBService{
+		client:            client,
+		logger:            opts.Logger,
+		table:             opts.Table,
+		schema:            schema,
+		validateTable:     opts.ValidateTable,
+		repairTTL:         opts.RepairTTL,
+		releasedRetention: opts.ReleasedItemRetention,
+	}, nil
+}
+
+// newDynamoDBClient returns the client given by WithDynamoDBClient,
+// or a new client built from the config given by WithAWSConfig or from the default config.
+func newDynamoDBClient(opts *Options) (DynamoDBAPI, error) {
+	if opts.DynamoDBClient != nil {
+		return opts.DynamoDBClient, nil
+	}
+	if opts.AWSConfig != nil {
+		return dynamodb.NewFromConfig(*opts.AWSConfig, func(o *dynamodb.Options) {
+			if opts.Region != "" {
+				o.Region = opts.Region
+			}
+			if opts.Endpoint != "" {
+				o.BaseEndpoint = aws.String(opts.Endpoint)
+			}
+		}), nil
+	}
 	if opts.Region == "" {
 		opts.Region = os.Getenv("AWS_DEFAULT_REGION")
 		if opts.Region == "" {
@@ -95,75 +181,70 @@ func newDynamoDBService(opts *Options) (*dynamoDBService, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &dynamoDBService{
-		client: dynamodb.NewFromConfig(awsCfg),
-		logger: opts.Logger,
-	}, nil
+	return dynamodb.NewFromConfig(awsCfg), nil
 }
 
 var checkTableRetryPolicy = retry.Policy{
 	MinDelay: 200 * time.Millisecond,
 	MaxDelay: 2 * time.Second,
-	MaxCount: 10,
 }
 
+// waitLockTableActive waits for the table to become active until the ActiveTimeout of the table options.
 func (svc *dynamoDBService) waitLockTableActive(ctx context.Context, tableName string) error {
+	ctx, cancel := context.WithTimeout(ctx, svc.table.ActiveTimeout)
+	defer cancel()
 	retrier := checkTableRetryPolicy.Start(ctx)
 	var err error
 	var exists bool
 	for retrier.Continue() {
-		exists, err = svc.LockTableExists(ctx, tableName)
+		_, exists, err = svc.describeLockTable(ctx, tableName)
 		if err == nil && exists {
 			return nil
 		}
 		svc.logger.Println("[debug][setddblock] retry lock until table active, table exists")
 	}
 	if err == nil {
-		return fmt.Errorf("table not active")
+		return fmt.Errorf("%w: %s", ErrTableNotActive, tableName)
 	}
-	return fmt.Errorf("table not active: %w", err)
+	return fmt.Errorf("%w: %s: %s", ErrTableNotActive, tableName, err)
 }
 
 func (svc *dynamoDBService) LockTableExists(ctx context.Context, tableName string) (bool, error) {
+	table, exists, err := svc.describeLockTable(ctx, tableName)
+	if err != nil || !exists {
+		return false, err
+	}
+	if svc.validateTable {
+		if err := svc.validateLockTable(ctx, table); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// describeLockTable returns the description of the table, and whether the table is active.
+func (svc *dynamoDBService) describeLockTable(ctx context.Context, tableName string) (*types.TableDescription, bool, error) {
 	table, err := svc.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
 		TableName: &tableName,
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "ResourceNotFoundException") {
+		if isAPIError(err, "ResourceNotFoundException") {
 			svc.logger.Printf("[debug][setddblock] lock not granted for table_name=%s", tableName)
-			return false, nil
+			return nil, false, nil
 		}
-		return false, err
+		return nil, false, err
 	}
 	svc.logger.Printf("[debug][setddblock] table `%s` status is %s", tableName, table.Table.TableStatus)
 	exists := table.Table.TableStatus == types.TableStatusActive || table.Table.TableStatus == types.TableStatusUpdating
 	svc.logger.Printf("[debug][setddblock] lock table `%s` exists = %v", tableName, exists)
-	if exists {
-		return true, nil
-	}
-	return false, nil
+	return table.Table, exists, nil
 }
 
 func (svc *dynamoDBService) CreateLockTable(ctx context.Context, tableName string) error {
 	svc.logger.Printf("[debug][setddblock] try - create table `%s`", tableName)
-	output, err := svc.client.CreateTable(ctx, &dynamodb.CreateTableInput{
-		TableName: &tableName,
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("ID"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("ID"),
-				KeyType:       types.KeyTypeHash,
-			},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-	})
+	output, err := svc.client.CreateTable(ctx, svc.createTableInput(tableName))
 	if err != nil {
-		if strings.Contains(err.Error(), "ResourceInUseException") {
+		if isAPIError(err, "ResourceInUseException") {
 			if err := svc.waitLockTableActive(ctx, tableName); err != nil {
 				return err
 			}
@@ -187,40 +268,81 @@ func (svc *dynamoDBService) CreateLockTable(ctx context.Context, tableName strin
 		return err
 	}
 	svc.logger.Printf("[debug][setddblock] success - update TTL `%s`", tableName)
+	if svc.table.PointInTimeRecovery {
+		svc.logger.Printf("[debug][setddblock] try - enable point-in-time recovery `%s`", tableName)
+		_, err = svc.client.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+			TableName: &tableName,
+			PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+				PointInTimeRecoveryEnabled: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		svc.logger.Printf("[debug][setddblock] success - enable point-in-time recovery `%s`", tableName)
+	}
 	return nil
 }
 
-type lockInput struct {
-	TableName     string
-	ItemID        string
-	Revision      string
-	PrevRevision  *string
-	LeaseDuration time.Duration
-}
-
-func (parms *lockInput) String() string {
-	prevRevision := "<nil>"
-	if parms.PrevRevision != nil {
-		prevRevision = *parms.PrevRevision
+func (svc *dynamoDBService) createTableInput(tableName string) *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		TableName: &tableName,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("ID"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("ID"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+	if svc.table.ReadCapacityUnits > 0 || svc.table.WriteCapacityUnits > 0 {
+		input.BillingMode = types.BillingModeProvisioned
+		input.ProvisionedThroughput = &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(svc.table.ReadCapacityUnits),
+			WriteCapacityUnits: aws.Int64(svc.table.WriteCapacityUnits),
+		}
+	}
+	keys := make([]string, 0, len(svc.table.Tags))
+	for key := range svc.table.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		input.Tags = append(input.Tags, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(svc.table.Tags[key]),
+		})
+	}
+	if svc.table.SSE {
+		input.SSESpecification = &types.SSESpecification{
+			Enabled: aws.Bool(true),
+			SSEType: types.SSETypeKms,
+		}
+		if svc.table.SSEKMSKeyID != "" {
+			input.SSESpecification.KMSMasterKeyId = aws.String(svc.table.SSEKMSKeyID)
+		}
+	}
+	if svc.table.DeletionProtection {
+		input.DeletionProtectionEnabled = aws.Bool(true)
 	}
-	return fmt.Sprintf(
-		"item_id=%s, lease_duration=%s, revision=%s, prev_revision=%s",
-		parms.ItemID,
-		parms.LeaseDuration,
-		parms.Revision,
-		prevRevision,
-	)
+	return input
 }
 
-func (parms *lockInput) caluTime() (time.Time, time.Time) {
-	nextHeartbeatLimit := time.Now().Add(parms.LeaseDuration)
-	ttl := nextHeartbeatLimit.Add(parms.LeaseDuration / 2).Truncate(time.Second).Add(time.Second)
-	return nextHeartbeatLimit, ttl
+func (parms *LockInput) caluTime() time.Time {
+	return time.Now().Add(parms.LeaseDuration)
 }
 
-func (parms *lockInput) Item() (map[string]types.AttributeValue, time.Time) {
-	nextHeartbeatLimit, ttl := parms.caluTime()
-	return map[string]types.AttributeValue{
+// item returns the attributes of a held lock item.
+// A held item has no ttl, so that DynamoDB never deletes it with its fencing token, even if the holder has crashed.
+func (parms *LockInput) item() (map[string]types.AttributeValue, time.Time) {
+	nextHeartbeatLimit := parms.caluTime()
+	item := map[string]types.AttributeValue{
 		"ID": &types.AttributeValueMemberS{
 			Value: parms.ItemID,
 		},
@@ -230,36 +352,144 @@ func (parms *lockInput) Item() (map[string]types.AttributeValue, time.Time) {
 		"Revision": &types.AttributeValueMemberS{
 			Value: parms.Revision,
 		},
-		"ttl": &types.AttributeValueMemberN{
-			Value: strconv.FormatInt(ttl.Unix(), 10),
+		"Expires": &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(nextHeartbeatLimit.UnixMilli(), 10),
+		},
+		"OwnerName": &types.AttributeValueMemberS{
+			Value: parms.Owner.Name,
+		},
+		"OwnerHostname": &types.AttributeValueMemberS{
+			Value: parms.Owner.Hostname,
+		},
+		"OwnerPID": &types.AttributeValueMemberN{
+			Value: strconv.Itoa(parms.Owner.PID),
 		},
-	}, nextHeartbeatLimit
+		"OwnerProcessStartTime": &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(parms.Owner.ProcessStartTime.UnixMilli(), 10),
+		},
+		"AcquiredAt": &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		},
+	}
+	if parms.Payload != nil {
+		item["Payload"] = &types.AttributeValueMemberB{
+			Value: parms.Payload,
+		}
+	}
+	return item, nextHeartbeatLimit
+}
+
+var (
+	// leaseAttributes are written by every acquisition and heartbeat.
+	// Payload is written only when it is given.
+	leaseAttributes = []string{"LeaseDuration", "Revision", "Expires", "Payload"}
+	// ownerAttributes are written only by acquisitions.
+	ownerAttributes = []string{"OwnerName", "OwnerHostname", "OwnerPID", "OwnerProcessStartTime", "AcquiredAt"}
+)
+
+// setExpression returns the SET clause that writes the attributes of the item,
+// and defines the expression attribute names and values used by the clause.
+// Attributes missing from the item are skipped.
+func setExpression(item map[string]types.AttributeValue, names map[string]string, values map[string]types.AttributeValue, attributes ...[]string) string {
+	var assignments []string
+	for _, attrs := range attributes {
+		for _, attr := range attrs {
+			if _, ok := item[attr]; !ok {
+				continue
+			}
+			names["#"+attr] = attr
+			values[":"+attr] = item[attr]
+			assignments = append(assignments, "#"+attr+"=:"+attr)
+		}
+	}
+	return "SET " + strings.Join(assignments, ",")
+}
+
+// clearPayloadExpression returns the REMOVE clause of a heartbeat that clears the payload, or an empty string.
+func clearPayloadExpression(parms *LockInput, names map[string]string) string {
+	if !parms.ClearPayload || parms.Payload != nil {
+		return ""
+	}
+	names["#Payload"] = "Payload"
+	return " REMOVE #Payload"
+}
+
+// acquireExpression returns the update expression written by every acquisition.
+// It writes the lease and owner attributes, increments the fencing token,
+// and removes the payload of the previous holder unless a new payload is given.
+// It also removes the ttl of a released item, so that the held item is not deleted.
+// removes are additional paths for the REMOVE clause.
+func acquireExpression(item map[string]types.AttributeValue, names map[string]string, values map[string]types.AttributeValue, removes ...string) string {
+	updateExpression := setExpression(item, names, values, leaseAttributes, ownerAttributes) + " ADD #FencingToken :One"
+	names["#FencingToken"] = "FencingToken"
+	values[":One"] = &types.AttributeValueMemberN{Value: "1"}
+	names["#ttl"] = "ttl"
+	removes = append(removes, "#ttl")
+	if _, ok := item["Payload"]; !ok {
+		names["#Payload"] = "Payload"
+		removes = append(removes, "#Payload")
+	}
+	if len(removes) > 0 {
+		updateExpression += " REMOVE " + strings.Join(removes, ",")
+	}
+	return updateExpression
+}
+
+// leaseExpiry returns the end of the lease of the item from the Expires attribute in unix milliseconds.
+// ttl is only for the garbage collection of DynamoDB, and is used only for items written without Expires.
+func leaseExpiry(item map[string]types.AttributeValue) (time.Time, bool) {
+	if expires, ok := readAttributeValueMemberN(item, "Expires"); ok {
+		return time.UnixMilli(expires), true
+	}
+	if ttl, ok := readAttributeValueMemberN(item, "ttl"); ok {
+		return time.Unix(ttl, 0), true
+	}
+	return time.Time{}, false
 }
 
-type lockOutput struct {
-	LockGranted        bool
-	LeaseDuration      time.Duration
-	NextHeartbeatLimit time.Time
-	Revision           string
+// leaseExpired reports whether the lease ending at expiry has expired at now, which is never the case with IgnoreExpiry.
+func (parms *LockInput) leaseExpired(expiry, now time.Time) bool {
+	return !parms.IgnoreExpiry && now.After(expiry)
+}
+
+// expiredCondition returns the condition that the lease of the item has expired at now,
+// and defines the expression attribute names and values used by the condition.
+func expiredCondition(names map[string]string, values map[string]types.AttributeValue, now time.Time) string {
+	names["#Expires"] = "Expires"
+	names["#ttl"] = "ttl"
+	values[":NowMillis"] = &types.AttributeValueMemberN{
+		Value: strconv.FormatInt(now.UnixMilli(), 10),
+	}
+	values[":Now"] = &types.AttributeValueMemberN{
+		Value: strconv.FormatInt(now.Unix(), 10),
+	}
+	return "(#Expires < :NowMillis OR (attribute_not_exists(#Expires) AND #ttl < :Now))"
+}
+
+func readOwner(item map[string]types.AttributeValue) (Owner, time.Time) {
+	var owner Owner
+	owner.Name, _ = readAttributeValueMemberS(item, "OwnerName")
+	owner.Hostname, _ = readAttributeValueMemberS(item, "OwnerHostname")
+	if pid, ok := readAttributeValueMemberN(item, "OwnerPID"); ok {
+		owner.PID = int(pid)
+	}
+	if startTime, ok := readAttributeValueMemberN(item, "OwnerProcessStartTime"); ok {
+		owner.ProcessStartTime = time.UnixMilli(startTime)
+	}
+	var acquiredAt time.Time
+	if t, ok := readAttributeValueMemberN(item, "AcquiredAt"); ok {
+		acquiredAt = time.UnixMilli(t)
+	}
+	return owner, acquiredAt
 }
 
 var (
 	errMaybeRaceDeleted = errors.New("maybe race")
 )
 
-func (output *lockOutput) String() string {
-	return fmt.Sprintf(
-		"lock_granted=%v, lease_duration=%s, revision=%s, next_heartbeat_limit=%s",
-		output.LockGranted,
-		output.LeaseDuration,
-		output.Revision,
-		output.NextHeartbeatLimit,
-	)
-}
-
-func (svc *dynamoDBService) AcquireLock(ctx context.Context, parms *lockInput) (*lockOutput, error) {
+func (svc *dynamoDBService) AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
 	svc.logger.Printf("[debug][setddblock] AcquireLock for table_name=%s, item_id=%s, lease_duration=%s, revision=%s, prev_revision=%v at %s", parms.TableName, parms.ItemID, parms.LeaseDuration, parms.Revision, parms.PrevRevision, time.Now().Format(time.RFC3339))
-	var ret *lockOutput
+	var ret *LockOutput
 	var err error
 	if parms.PrevRevision == nil {
 		ret, err = svc.putItemForLock(ctx, parms)
@@ -287,32 +517,44 @@ func (svc *dynamoDBService) AcquireLock(ctx context.Context, parms *lockInput) (
 	return nil, err
 }
 
-func (svc *dynamoDBService) putItemForLock(ctx context.Context, parms *lockInput) (*lockOutput, error) {
-	item, nextHeartbeatLimit := parms.Item()
+// putItemForLock writes the lock attributes when no one holds the item.
+// UpdateItem is used instead of PutItem, so that the fencing token of a released item keeps increasing.
+func (svc *dynamoDBService) putItemForLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	item, nextHeartbeatLimit := parms.item()
 	svc.logger.Printf("[debug][setddblock] try - put item in ddb")
-	_, err := svc.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName:           &parms.TableName,
-		Item:                item,
-		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	updateExpression := acquireExpression(item, names, values)
+	output, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": item["ID"],
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String("attribute_not_exists(#Revision)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
 	})
 	if err == nil {
-		_, ttl := parms.caluTime()
-		svc.logger.Printf("[debug][setddblock] lock granted with TTL: %d", ttl.Unix())
-		return &lockOutput{
+		fencingToken, _ := readAttributeValueMemberN(output.Attributes, "FencingToken")
+		svc.logger.Printf("[debug][setddblock] lock granted until %s, fencing_token: %d", nextHeartbeatLimit.Format(time.RFC3339Nano), fencingToken)
+		return &LockOutput{
 			LockGranted:        true,
 			LeaseDuration:      parms.LeaseDuration,
 			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
 			Revision:           parms.Revision,
+			FencingToken:       fencingToken,
 		}, nil
 	}
-	if strings.Contains(err.Error(), "ConditionalCheckFailedException") {
+	if isConditionalCheckFailed(err) {
 		svc.logger.Printf("[debug][setddblock] not lock granted")
 		return svc.getItemForLock(ctx, parms)
 	}
 	return nil, err
 }
 
-func (svc *dynamoDBService) getItemForLock(ctx context.Context, parms *lockInput) (*lockOutput, error) {
+func (svc *dynamoDBService) getItemForLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
 	svc.logger.Printf("[debug][setddblock] try - get item table_name=%s, item_id=%s", parms.TableName, parms.ItemID)
 	output, err := svc.client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: &parms.TableName,
@@ -327,8 +569,7 @@ func (svc *dynamoDBService) getItemForLock(ctx context.Context, parms *lockInput
 	if err != nil {
 		return nil, err
 	}
-	_, ttl := parms.caluTime()
-	svc.logger.Printf("[debug][setddblock] success - get item for table_name=%s, item_id=%s, TTL: %d, current_time=%d", parms.TableName, parms.ItemID, ttl.Unix(), time.Now().Unix())
+	svc.logger.Printf("[debug][setddblock] success - get item for table_name=%s, item_id=%s, current_time=%d", parms.TableName, parms.ItemID, time.Now().Unix())
 	n, ok := readAttributeValueMemberN(output.Item, "LeaseDuration")
 	if !ok {
 		return nil, errMaybeRaceDeleted
@@ -339,22 +580,16 @@ func (svc *dynamoDBService) getItemForLock(ctx context.Context, parms *lockInput
 		return nil, errMaybeRaceDeleted
 	}
 
-	ttlValue, ok := readAttributeValueMemberN(output.Item, "ttl")
+	expiry, ok := leaseExpiry(output.Item)
 	if !ok {
 		return nil, errMaybeRaceDeleted
 	}
-
-	if time.Now().Unix() > ttlValue {
-		svc.logger.Printf("[debug][setddblock] TTL has expired for item_id=%s, TTL=%d, current_time=%d, table_name=%s", parms.ItemID, ttlValue, time.Now().Unix(), parms.TableName)
-		return &lockOutput{
-			LockGranted:        true,
-			LeaseDuration:      leaseDuration,
-			Revision:           revision,
-			NextHeartbeatLimit: time.Now().Add(leaseDuration).Truncate(time.Millisecond),
-		}, nil
+	if now := time.Now(); parms.leaseExpired(expiry, now) {
+		svc.logger.Printf("[debug][setddblock] lease has expired for item_id=%s, expires=%s, current_time=%s, table_name=%s", parms.ItemID, expiry.Format(time.RFC3339Nano), now.Format(time.RFC3339Nano), parms.TableName)
+		return svc.takeoverExpiredLock(ctx, parms, revision)
 	}
 
-	return &lockOutput{
+	return &LockOutput{
 		LockGranted:        false,
 		LeaseDuration:      leaseDuration,
 		Revision:           revision,
@@ -362,6 +597,48 @@ func (svc *dynamoDBService) getItemForLock(ctx context.Context, parms *lockInput
 	}, nil
 }
 
+// takeoverExpiredLock acquires the item whose lease has expired, with a conditional write on the expired revision.
+// When several contenders see the same expired lease, only one of them wins, and the others see the revision of the winner.
+func (svc *dynamoDBService) takeoverExpiredLock(ctx context.Context, parms *LockInput, expiredRevision string) (*LockOutput, error) {
+	item, nextHeartbeatLimit := parms.item()
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{
+		":ExpiredRevision": &types.AttributeValueMemberS{
+			Value: expiredRevision,
+		},
+	}
+	updateExpression := acquireExpression(item, names, values)
+	condition := "#Revision=:ExpiredRevision AND " + expiredCondition(names, values, time.Now())
+	output, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": item["ID"],
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err == nil {
+		fencingToken, _ := readAttributeValueMemberN(output.Attributes, "FencingToken")
+		svc.logger.Printf("[debug][setddblock] took over expired revision=%s, fencing_token: %d", expiredRevision, fencingToken)
+		return &LockOutput{
+			LockGranted:        true,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+			Revision:           parms.Revision,
+			FencingToken:       fencingToken,
+		}, nil
+	}
+	if isConditionalCheckFailed(err) {
+		// someone else has taken over or released the item, read it again.
+		svc.logger.Printf("[debug][setddblock] failed to take over expired revision=%s", expiredRevision)
+		return nil, errMaybeRaceDeleted
+	}
+	return nil, err
+}
+
 func readAttributeValueMemberN(item map[string]types.AttributeValue, key string) (int64, bool) {
 	v, ok := item[key]
 	if !ok {
@@ -390,52 +667,64 @@ func readAttributeValueMemberS(item map[string]types.AttributeValue, key string)
 	return s.Value, true
 }
 
-func (svc *dynamoDBService) updateItemForLock(ctx context.Context, parms *lockInput) (*lockOutput, error) {
+func (svc *dynamoDBService) updateItemForLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
 	svc.logger.Printf("[debug][setddblock] try - update item in ddb")
-	ret, err := svc.updateItem(ctx, parms)
+	ret, err := svc.updateItem(ctx, parms, true)
 	if err == nil {
 		svc.logger.Printf("[debug][setddblock] success - update item in ddb")
 		svc.logger.Printf("[debug][setddblock] lock granted")
 		return ret, nil
 	}
-	if strings.Contains(err.Error(), "ConditionalCheckFailedException") {
+	if isConditionalCheckFailed(err) {
 		svc.logger.Printf("[debug][setddblock] not lock granted")
 		return svc.getItemForLock(ctx, parms)
 	}
 	return nil, err
 }
 
-func (svc *dynamoDBService) updateItem(ctx context.Context, parms *lockInput) (*lockOutput, error) {
-	item, nextHeartbeatLimit := parms.Item()
-	_, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (svc *dynamoDBService) updateItem(ctx context.Context, parms *LockInput, acquire bool) (*LockOutput, error) {
+	item, nextHeartbeatLimit := parms.item()
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{
+		":PrevRevision": &types.AttributeValueMemberS{
+			Value: *parms.PrevRevision,
+		},
+	}
+	// a missing item is written again, unless the lease must be still held by PrevRevision.
+	condition := "attribute_not_exists(#ID) OR #Revision=:PrevRevision"
+	if parms.RequireHeld && !acquire {
+		condition = "attribute_exists(#Revision) AND #Revision=:PrevRevision"
+	} else {
+		names["#ID"] = "ID"
+	}
+	var updateExpression string
+	if acquire {
+		// every acquisition increments the fencing token and records the owner, heartbeats keep them.
+		updateExpression = acquireExpression(item, names, values)
+	} else {
+		updateExpression = setExpression(item, names, values, leaseAttributes) + clearPayloadExpression(parms, names)
+	}
+	output, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: &parms.TableName,
 		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{
-				Value: parms.ItemID,
-			},
-		},
-		UpdateExpression:    aws.String("SET #LeaseDuration=:LeaseDuration,#Revision=:Revision,#ttl=:ttl"),
-		ConditionExpression: aws.String("attribute_not_exists(ID) OR Revision=:PrevRevision"),
-		ExpressionAttributeNames: map[string]string{
-			"#LeaseDuration": "LeaseDuration",
-			"#Revision":      "Revision",
-			"#ttl":           "ttl",
-		},
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":LeaseDuration": item["LeaseDuration"],
-			":Revision":      item["Revision"],
-			":ttl":           item["ttl"],
-			":PrevRevision": &types.AttributeValueMemberS{
-				Value: *parms.PrevRevision,
-			},
+			"ID": item["ID"],
 		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+		// a heartbeat reads the record of ForceRelease on the item when it has been evicted.
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	})
 	if err == nil {
-		return &lockOutput{
+		fencingToken, _ := readAttributeValueMemberN(output.Attributes, "FencingToken")
+		return &LockOutput{
 			LockGranted:        true,
 			LeaseDuration:      parms.LeaseDuration,
 			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
 			Revision:           parms.Revision,
+			FencingToken:       fencingToken,
 		}, nil
 	}
 	return nil, err
@@ -447,25 +736,28 @@ var retryPolicy = retry.Policy{
 	MaxCount: 10,
 }
 
-func (svc *dynamoDBService) SendHeartbeat(ctx context.Context, parms *lockInput) (*lockOutput, error) {
+func (svc *dynamoDBService) SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
 	svc.logger.Printf("[debug][setddblock] sendHeartbeat %s", parms)
 	if parms.PrevRevision == nil {
 		return nil, errors.New("prev revision is must need")
 	}
 	retrier := retryPolicy.Start(ctx)
 	var err error
-	var ret *lockOutput
+	var ret *LockOutput
 	for retrier.Continue() {
-		ret, err = svc.updateItem(ctx, parms)
+		ret, err = svc.updateItem(ctx, parms, false)
 		if err == nil {
 			return ret, nil
 		}
+		if isConditionalCheckFailed(err) {
+			return nil, &HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: heartbeatLost(err, *parms.PrevRevision)}
+		}
 		svc.logger.Printf("[warn][setddblock] send heartbeat failed retrying %s, err=%s", parms, err)
 	}
-	return nil, fmt.Errorf("heartbeet failed: %w", err)
+	return nil, &HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: err}
 }
 
-func (svc *dynamoDBService) ReleaseLock(ctx context.Context, parms *lockInput) error {
+func (svc *dynamoDBService) ReleaseLock(ctx context.Context, parms *LockInput) error {
 	if parms.PrevRevision == nil {
 		return errors.New("prev revision is must need")
 	}
@@ -481,28 +773,67 @@ func (svc *dynamoDBService) ReleaseLock(ctx context.Context, parms *lockInput) e
 	return fmt.Errorf("release lock failed: %w", err)
 }
 
-func (svc *dynamoDBService) deleteItemForUnlock(ctx context.Context, parms *lockInput) error {
-	svc.logger.Printf("[debug][setddblock] try - delete item to ddb")
-	_, err := svc.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+// deleteItemForUnlock removes the lock attributes from the item.
+// The item itself is kept until the released item retention, so that the fencing token survives the release.
+func (svc *dynamoDBService) deleteItemForUnlock(ctx context.Context, parms *LockInput) error {
+	svc.logger.Printf("[debug][setddblock] try - remove lock attributes from ddb")
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{
+		":PrevRevision": &types.AttributeValueMemberS{
+			Value: *parms.PrevRevision,
+		},
+	}
+	_, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: &parms.TableName,
 		Key: map[string]types.AttributeValue{
 			"ID": &types.AttributeValueMemberS{
 				Value: parms.ItemID,
 			},
 		},
-		ConditionExpression: aws.String("attribute_exists(ID) AND Revision=:PrevRevision"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":PrevRevision": &types.AttributeValueMemberS{
-				Value: *parms.PrevRevision,
-			},
-		},
+		UpdateExpression:          aws.String(svc.releaseExpression(names, values)),
+		ConditionExpression:       aws.String("attribute_exists(#Revision) AND #Revision=:PrevRevision"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
 	})
 	if err == nil {
-		svc.logger.Printf("[debug][setddblock] success - delete item from ddb")
+		svc.logger.Printf("[debug][setddblock] success - remove lock attributes from ddb")
 		return nil
 	}
-	if strings.Contains(err.Error(), "ConditionalCheckFailedException") {
+	if isConditionalCheckFailed(err) {
 		return nil
 	}
 	return err
 }
+
+// releasedAttributes are removed from a released lock item. ttl is removed too when released items are kept.
+var releasedAttributes = []string{"LeaseDuration", "Revision", "Expires", "Payload", "OwnerName", "OwnerHostname", "OwnerPID", "OwnerProcessStartTime", "AcquiredAt"}
+
+// releaseExpression returns the update expression that releases a lock item, with the assignments of sets,
+// and defines the expression attribute names and values used by it.
+// The item keeps the fencing token, and its TTL is set to the end of the released item retention if any.
+func (svc *dynamoDBService) releaseExpression(names map[string]string, values map[string]types.AttributeValue, sets ...string) string {
+	return svc.releaseExpressionAt(time.Now(), names, values, sets...)
+}
+
+// releaseExpressionAt is releaseExpression of an item that is released at releasedAt, from which the retention is counted.
+func (svc *dynamoDBService) releaseExpressionAt(releasedAt time.Time, names map[string]string, values map[string]types.AttributeValue, sets ...string) string {
+	removes := make([]string, 0, len(releasedAttributes)+1)
+	for _, attr := range releasedAttributes {
+		names["#"+attr] = attr
+		removes = append(removes, "#"+attr)
+	}
+	names["#ttl"] = "ttl"
+	if svc.releasedRetention > 0 {
+		values[":ReleasedTTL"] = &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(releasedAt.Add(svc.releasedRetention).Unix(), 10),
+		}
+		sets = append(sets, "#ttl=:ReleasedTTL")
+	} else {
+		removes = append(removes, "#ttl")
+	}
+	updateExpression := "REMOVE " + strings.Join(removes, ",")
+	if len(sets) > 0 {
+		updateExpression = "SET " + strings.Join(sets, ",") + " " + updateExpression
+	}
+	return updateExpression
+}
@@ -0,0 +1,131 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var _ Breaker = (*dynamoDBService)(nil)
+
+// ForceRelease removes the lock attributes like ReleaseLock, and records the breaker in the Broken map attribute
+// with the broken revision, by which the evicted holder recognizes its own eviction.
+// The update is conditioned on both the revision read from the item and the expected fencing token,
+// and it reads the item again when a heartbeat has renewed the revision in between.
+func (svc *dynamoDBService) ForceRelease(ctx context.Context, parms *ForceReleaseInput) error {
+	output, err := svc.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: parms.ItemID,
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	current := output.Item
+	for {
+		revision, ok := readAttributeValueMemberS(current, "Revision")
+		if !ok || revision == "" {
+			return fmt.Errorf("%w: item_id=%s, table_name=%s", ErrNotLocked, parms.ItemID, parms.TableName)
+		}
+		fencingToken, _ := readAttributeValueMemberN(current, "FencingToken")
+		if fencingToken != parms.FencingToken {
+			return fmt.Errorf("%w: item_id=%s, table_name=%s, fencing_token=%d, expected_fencing_token=%d", ErrFencingTokenMismatch, parms.ItemID, parms.TableName, fencingToken, parms.FencingToken)
+		}
+		err := svc.forceRelease(ctx, parms, revision)
+		if err == nil {
+			return nil
+		}
+		if !isConditionalCheckFailed(err) {
+			return err
+		}
+		var condErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &condErr) {
+			return err
+		}
+		svc.logger.Printf("[debug][setddblock] revision of item_id=%s, table_name=%s changed during force release, retry", parms.ItemID, parms.TableName)
+		current = condErr.Item
+	}
+}
+
+func (svc *dynamoDBService) forceRelease(ctx context.Context, parms *ForceReleaseInput, revision string) error {
+	names := map[string]string{
+		"#Broken":       "Broken",
+		"#FencingToken": "FencingToken",
+	}
+	values := map[string]types.AttributeValue{
+		":PrevRevision": &types.AttributeValueMemberS{
+			Value: revision,
+		},
+		":FencingToken": &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(parms.FencingToken, 10),
+		},
+		":Broken": &types.AttributeValueMemberM{
+			Value: map[string]types.AttributeValue{
+				"Revision":              &types.AttributeValueMemberS{Value: revision},
+				"OwnerName":             &types.AttributeValueMemberS{Value: parms.BrokenBy.Name},
+				"OwnerHostname":         &types.AttributeValueMemberS{Value: parms.BrokenBy.Hostname},
+				"OwnerPID":              &types.AttributeValueMemberN{Value: strconv.Itoa(parms.BrokenBy.PID)},
+				"OwnerProcessStartTime": &types.AttributeValueMemberN{Value: strconv.FormatInt(parms.BrokenBy.ProcessStartTime.UnixMilli(), 10)},
+				"BrokenAt":              &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().UnixMilli(), 10)},
+				"Reason":                &types.AttributeValueMemberS{Value: parms.Reason},
+			},
+		},
+	}
+	_, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: parms.ItemID,
+			},
+		},
+		UpdateExpression:                    aws.String(svc.releaseExpression(names, values, "#Broken=:Broken")),
+		ConditionExpression:                 aws.String("attribute_exists(#Revision) AND #Revision=:PrevRevision AND #FencingToken=:FencingToken"),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	return err
+}
+
+// heartbeatLost returns the error of a heartbeat whose condition on the revision failed, which wraps ErrLockLost.
+// It is *LockBrokenError if the revision was broken by ForceRelease.
+func heartbeatLost(err error, revision string) error {
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		if broken := readLockBroken(condErr.Item, revision); broken != nil {
+			return broken
+		}
+	}
+	return lockLost(err)
+}
+
+// readLockBroken returns the record of ForceRelease on the item if it has broken the lease revision, or nil.
+func readLockBroken(item map[string]types.AttributeValue, revision string) *LockBrokenError {
+	m, ok := item["Broken"].(*types.AttributeValueMemberM)
+	if !ok {
+		return nil
+	}
+	broken := m.Value
+	if r, _ := readAttributeValueMemberS(broken, "Revision"); r != revision {
+		return nil
+	}
+	brokenBy, _ := readOwner(broken)
+	e := &LockBrokenError{
+		BrokenBy: brokenBy,
+	}
+	e.Reason, _ = readAttributeValueMemberS(broken, "Reason")
+	if t, ok := readAttributeValueMemberN(broken, "BrokenAt"); ok {
+		e.BrokenAt = time.UnixMilli(t)
+	}
+	return e
+}
@@ -0,0 +1,172 @@
+package setddblock_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/stretchr/testify/require"
+)
+
+// stubDynamoDB answers the calls of a lock and unlock of a free item, and records the operations.
+type stubDynamoDB struct {
+	setddblock.DynamoDBAPI
+	mu         sync.Mutex
+	operations []string
+}
+
+func (c *stubDynamoDB) record(operation string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.operations = append(c.operations, operation)
+}
+
+func (c *stubDynamoDB) DescribeTable(_ context.Context, params *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	c.record("DescribeTable")
+	return &dynamodb.DescribeTableOutput{
+		Table: &types.TableDescription{
+			TableName:   params.TableName,
+			TableStatus: types.TableStatusActive,
+		},
+	}, nil
+}
+
+func (c *stubDynamoDB) UpdateItem(_ context.Context, params *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.record("UpdateItem " + aws.ToString(params.ConditionExpression))
+	return &dynamodb.UpdateItemOutput{
+		Attributes: map[string]types.AttributeValue{
+			"FencingToken": &types.AttributeValueMemberN{Value: "7"},
+		},
+	}, nil
+}
+
+func TestWithDynamoDBClient(t *testing.T) {
+	client := &stubDynamoDB{}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.EqualValues(t, 7, locker.FencingToken())
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.Equal(t, []string{
+		"DescribeTable",
+		"UpdateItem attribute_not_exists(#Revision)",
+		"UpdateItem attribute_exists(#Revision) AND #Revision=:PrevRevision",
+	}, client.operations)
+}
+
+// releaseStubDynamoDB records the update expressions of the calls of stubDynamoDB.
+type releaseStubDynamoDB struct {
+	stubDynamoDB
+	updates []*dynamodb.UpdateItemInput
+}
+
+func (c *releaseStubDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.updates = append(c.updates, params)
+	return c.stubDynamoDB.UpdateItem(ctx, params, optFns...)
+}
+
+func TestReleasedItemRetention(t *testing.T) {
+	cases := []struct {
+		retention  time.Duration
+		expression string
+	}{
+		{
+			retention:  24 * time.Hour,
+			expression: "SET #ttl=:ReleasedTTL REMOVE #LeaseDuration,#Revision,#Expires,#Payload,#OwnerName,#OwnerHostname,#OwnerPID,#OwnerProcessStartTime,#AcquiredAt",
+		},
+		{
+			retention:  0,
+			expression: "REMOVE #LeaseDuration,#Revision,#Expires,#Payload,#OwnerName,#OwnerHostname,#OwnerPID,#OwnerProcessStartTime,#AcquiredAt,#ttl",
+		},
+	}
+	for _, c := range cases {
+		client := &releaseStubDynamoDB{}
+		locker, err := setddblock.New(
+			"ddb://test/item1",
+			setddblock.WithDynamoDBClient(client),
+			setddblock.WithDelay(false),
+			setddblock.WithReleasedItemRetention(c.retention),
+		)
+		require.NoError(t, err)
+		ctx := context.Background()
+		granted, err := locker.LockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted)
+		require.NoError(t, locker.UnlockWithErr(ctx))
+		require.Len(t, client.updates, 2)
+		acquire := client.updates[0]
+		require.NotContains(t, acquire.ExpressionAttributeValues, ":ttl", "a held item is never deleted by TTL")
+		require.Contains(t, aws.ToString(acquire.UpdateExpression), "REMOVE #ttl", "the TTL of a released item is removed by the acquisition")
+		release := client.updates[1]
+		require.Equal(t, c.expression, aws.ToString(release.UpdateExpression))
+		if c.retention > 0 {
+			ttl, err := strconv.ParseInt(release.ExpressionAttributeValues[":ReleasedTTL"].(*types.AttributeValueMemberN).Value, 10, 64)
+			require.NoError(t, err)
+			require.InDelta(t, time.Now().Add(c.retention).Unix(), ttl, 2, "the released item is deleted by TTL after the retention")
+		}
+	}
+
+	_, err := setddblock.New("ddb://test/item1", setddblock.WithDynamoDBClient(&stubDynamoDB{}), setddblock.WithReleasedItemRetention(-time.Second))
+	require.Error(t, err)
+}
+
+func TestReadLockReleasesWriterItem(t *testing.T) {
+	client := &releaseStubDynamoDB{}
+	locker, err := setddblock.NewRW(
+		"ddb://test/rw",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithReleasedItemRetention(time.Hour),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	granted, err := locker.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, locker.RUnlockWithErr(ctx))
+	require.GreaterOrEqual(t, len(client.updates), 2)
+	readLock := client.updates[1]
+	require.Equal(t,
+		"SET #Readers.#Reader = :ReaderExpires,#ttl=:ReleasedTTL REMOVE #LeaseDuration,#Revision,#Expires,#Payload,#OwnerName,#OwnerHostname,#OwnerPID,#OwnerProcessStartTime,#AcquiredAt",
+		aws.ToString(readLock.UpdateExpression),
+		"the owner of the previous writer is removed",
+	)
+	ttl, err := strconv.ParseInt(readLock.ExpressionAttributeValues[":ReleasedTTL"].(*types.AttributeValueMemberN).Value, 10, 64)
+	require.NoError(t, err)
+	require.InDelta(t, time.Now().Add(setddblock.DefaultLeaseDuration+time.Hour).Unix(), ttl, 2, "the item is deleted the retention after the end of the reader lease")
+}
+
+func TestSetPayloadNilRemovesPayload(t *testing.T) {
+	client := &releaseStubDynamoDB{}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithPayload([]byte("job-1")),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, locker.SetPayload(ctx, nil))
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.Len(t, client.updates, 3)
+	heartbeat := client.updates[1]
+	require.True(t, strings.HasSuffix(aws.ToString(heartbeat.UpdateExpression), " REMOVE #Payload"), aws.ToString(heartbeat.UpdateExpression))
+	require.NotContains(t, heartbeat.ExpressionAttributeValues, ":Payload")
+}
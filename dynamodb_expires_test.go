@@ -0,0 +1,69 @@
+package setddblock_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/stretchr/testify/require"
+)
+
+// expiredItemStubDynamoDB holds an item whose lease has expired a moment ago, while its ttl is still in the future.
+type expiredItemStubDynamoDB struct {
+	stubDynamoDB
+	item map[string]types.AttributeValue
+}
+
+func (c *expiredItemStubDynamoDB) GetItem(_ context.Context, _ *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	c.record("GetItem")
+	return &dynamodb.GetItemOutput{Item: c.item}, nil
+}
+
+func (c *expiredItemStubDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if aws.ToString(params.ConditionExpression) == "attribute_not_exists(#Revision)" {
+		c.record("UpdateItem " + aws.ToString(params.ConditionExpression))
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	return c.stubDynamoDB.UpdateItem(ctx, params, optFns...)
+}
+
+func TestMillisecondLeaseExpiry(t *testing.T) {
+	now := time.Now()
+	client := &expiredItemStubDynamoDB{
+		item: map[string]types.AttributeValue{
+			"ID":            &types.AttributeValueMemberS{Value: "item1"},
+			"Revision":      &types.AttributeValueMemberS{Value: "crashed-holder"},
+			"LeaseDuration": &types.AttributeValueMemberN{Value: "100"},
+			"Expires":       &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(-50*time.Millisecond).UnixMilli(), 10)},
+			"ttl":           &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(2*time.Second).Unix(), 10)},
+		},
+	}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	details, err := locker.GetLockDetails(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-50*time.Millisecond).UnixMilli(), details.ExpirationTime.UnixMilli())
+
+	granted, err := locker.LockWithErr(context.Background())
+	require.NoError(t, err)
+	require.True(t, granted, "the lease has expired although ttl has not passed")
+	require.NoError(t, locker.UnlockWithErr(context.Background()))
+	require.Equal(t, []string{
+		"GetItem",
+		"DescribeTable",
+		"UpdateItem attribute_not_exists(#Revision)",
+		"GetItem",
+		"UpdateItem #Revision=:ExpiredRevision AND (#Expires < :NowMillis OR (attribute_not_exists(#Expires) AND #ttl < :Now))",
+		"UpdateItem attribute_exists(#Revision) AND #Revision=:PrevRevision",
+	}, client.operations)
+}
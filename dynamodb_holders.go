@@ -0,0 +1,199 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Shared lock holders, such as readers of a reader-writer lock and holders of a semaphore permit,
+// are kept in a map attribute of the lock item.
+// The key of the map is the revision of the holder's lease,
+// and the value is the expiration time of the lease in unix milliseconds.
+
+type holderLease struct {
+	revision string
+	expires  time.Time
+	value    types.AttributeValue
+}
+
+// readHolderLeases splits the leases in the map attribute into live and expired ones.
+// exists reports whether the map attribute exists.
+func readHolderLeases(item map[string]types.AttributeValue, key string, now time.Time) (live, expired []holderLease, exists bool) {
+	m, ok := item[key].(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, nil, false
+	}
+	for revision, v := range m.Value {
+		n, ok := v.(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		expires, err := strconv.ParseInt(n.Value, 10, 64)
+		if err != nil {
+			continue
+		}
+		lease := holderLease{
+			revision: revision,
+			expires:  time.UnixMilli(expires),
+			value:    v,
+		}
+		if lease.expires.Before(now) {
+			expired = append(expired, lease)
+		} else {
+			live = append(live, lease)
+		}
+	}
+	return live, expired, true
+}
+
+// removeExpiredHolders returns REMOVE paths for the expired leases,
+// and conditions that the expired leases have not been extended since they were read.
+// The expression attribute name "#<key>" must be defined by the caller.
+func removeExpiredHolders(key string, expired []holderLease, names map[string]string, values map[string]types.AttributeValue) ([]string, []string) {
+	removes := make([]string, 0, len(expired))
+	conditions := make([]string, 0, len(expired))
+	for i, lease := range expired {
+		names[fmt.Sprintf("#Expired%d", i)] = lease.revision
+		values[fmt.Sprintf(":Expired%d", i)] = lease.value
+		removes = append(removes, fmt.Sprintf("#%s.#Expired%d", key, i))
+		conditions = append(conditions, fmt.Sprintf("#%s.#Expired%d = :Expired%d", key, i, i))
+	}
+	return removes, conditions
+}
+
+func (svc *dynamoDBService) ensureHolderMap(ctx context.Context, parms *LockInput, key string) error {
+	_, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: parms.ItemID,
+			},
+		},
+		UpdateExpression: aws.String("SET #Holders = if_not_exists(#Holders, :Empty)"),
+		ExpressionAttributeNames: map[string]string{
+			"#Holders": key,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":Empty": &types.AttributeValueMemberM{
+				Value: map[string]types.AttributeValue{},
+			},
+		},
+	})
+	return err
+}
+
+func (svc *dynamoDBService) getItem(ctx context.Context, tableName, itemID string) (map[string]types.AttributeValue, error) {
+	output, err := svc.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &tableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: itemID,
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Item, nil
+}
+
+// sendHolderHeartbeat extends the lease PrevRevision in the map attribute and renames it to Revision.
+// If extendTTL is true, the ttl of the item is moved to the released item retention after the extended lease.
+func (svc *dynamoDBService) sendHolderHeartbeat(ctx context.Context, parms *LockInput, key string, extendTTL bool) (*LockOutput, error) {
+	if parms.PrevRevision == nil {
+		return nil, errors.New("prev revision is must need")
+	}
+	retrier := retryPolicy.Start(ctx)
+	var err error
+	for retrier.Continue() {
+		nextHeartbeatLimit := time.Now().Add(parms.LeaseDuration)
+		names := map[string]string{
+			"#Holders":    key,
+			"#Holder":     parms.Revision,
+			"#PrevHolder": *parms.PrevRevision,
+		}
+		values := map[string]types.AttributeValue{
+			":Expires": &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(nextHeartbeatLimit.UnixMilli(), 10),
+			},
+		}
+		updateExpression := "SET #Holders.#Holder = :Expires"
+		if extendTTL {
+			names["#ttl"] = "ttl"
+			values[":HolderTTL"] = &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(nextHeartbeatLimit.Add(svc.releasedRetention).Unix(), 10),
+			}
+			updateExpression += ",#ttl = :HolderTTL"
+		}
+		if *parms.PrevRevision != parms.Revision {
+			updateExpression += " REMOVE #Holders.#PrevHolder"
+		}
+		var output *dynamodb.UpdateItemOutput
+		output, err = svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: &parms.TableName,
+			Key: map[string]types.AttributeValue{
+				"ID": &types.AttributeValueMemberS{
+					Value: parms.ItemID,
+				},
+			},
+			UpdateExpression:          aws.String(updateExpression),
+			ConditionExpression:       aws.String("attribute_exists(#Holders.#PrevHolder)"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              types.ReturnValueAllNew,
+		})
+		if err == nil {
+			fencingToken, _ := readAttributeValueMemberN(output.Attributes, "FencingToken")
+			return &LockOutput{
+				LockGranted:        true,
+				LeaseDuration:      parms.LeaseDuration,
+				NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+				Revision:           parms.Revision,
+				FencingToken:       fencingToken,
+			}, nil
+		}
+		if isConditionalCheckFailed(err) {
+			return nil, &HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: lockLost(err)}
+		}
+		svc.logger.Printf("[warn][setddblock] send heartbeat failed retrying %s, err=%s", parms, err)
+	}
+	return nil, &HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: err}
+}
+
+// releaseHolder removes the lease PrevRevision from the map attribute.
+func (svc *dynamoDBService) releaseHolder(ctx context.Context, parms *LockInput, key string) error {
+	if parms.PrevRevision == nil {
+		return errors.New("prev revision is must need")
+	}
+	retrier := retryPolicy.Start(ctx)
+	var err error
+	for retrier.Continue() {
+		_, err = svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: &parms.TableName,
+			Key: map[string]types.AttributeValue{
+				"ID": &types.AttributeValueMemberS{
+					Value: parms.ItemID,
+				},
+			},
+			UpdateExpression:    aws.String("REMOVE #Holders.#PrevHolder"),
+			ConditionExpression: aws.String("attribute_exists(#Holders.#PrevHolder)"),
+			ExpressionAttributeNames: map[string]string{
+				"#Holders":    key,
+				"#PrevHolder": *parms.PrevRevision,
+			},
+		})
+		if err == nil || isConditionalCheckFailed(err) {
+			return nil
+		}
+		svc.logger.Printf("[warn][setddblock] release lock failed retrying %s, err=%s", parms, err)
+	}
+	return fmt.Errorf("release lock failed: %w", err)
+}
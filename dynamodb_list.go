@@ -0,0 +1,130 @@
+package setddblock
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var _ Lister = (*dynamoDBService)(nil)
+
+// ListLocks scans the lock table. The filter is applied by DynamoDB after the items are read,
+// so that Limit is the number of items read for a page, not the number of locks in it.
+func (svc *dynamoDBService) ListLocks(ctx context.Context, tableName string, filter LockFilter) (*LockPage, error) {
+	conditions := []string{"attribute_exists(#Revision)"}
+	names := map[string]string{
+		"#Revision": "Revision",
+	}
+	values := map[string]types.AttributeValue{}
+	if prefix := svc.schema.PartitionKeyPrefix + filter.ItemIDPrefix; prefix != "" {
+		conditions = append(conditions, "begins_with(#ID, :Prefix)")
+		names["#ID"] = "ID"
+		values[":Prefix"] = &types.AttributeValueMemberS{
+			Value: prefix,
+		}
+	}
+	if svc.schema.SortKey != "" {
+		// other entities of a single-table design share the partition key prefix.
+		names["#SK"] = svc.schema.SortKey
+		if svc.schema.SortKeyPrefix != "" {
+			conditions = append(conditions, "begins_with(#SK, :SortKey)")
+			values[":SortKey"] = &types.AttributeValueMemberS{
+				Value: svc.schema.SortKeyPrefix + filter.ItemIDPrefix,
+			}
+		} else {
+			conditions = append(conditions, "#SK = :SortKey")
+			values[":SortKey"] = &types.AttributeValueMemberS{
+				Value: svc.schema.SortKeyValue,
+			}
+		}
+	}
+	input := &dynamodb.ScanInput{
+		TableName:                &tableName,
+		FilterExpression:         aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames: names,
+		ConsistentRead:           aws.Bool(true),
+	}
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+	if filter.Limit > 0 {
+		input.Limit = aws.Int32(int32(filter.Limit))
+	}
+	if filter.PageToken != "" {
+		key, err := decodePageToken(filter.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		input.ExclusiveStartKey = key
+	}
+	output, err := svc.client.Scan(ctx, input)
+	if err != nil {
+		if isAPIError(err, "ResourceNotFoundException") {
+			return nil, fmt.Errorf("%w: %s", ErrTableNotFound, tableName)
+		}
+		return nil, err
+	}
+	page := &LockPage{
+		Locks: make([]*LockDetails, 0, len(output.Items)),
+	}
+	for _, item := range output.Items {
+		itemID, ok := readAttributeValueMemberS(item, "ID")
+		if !ok {
+			continue
+		}
+		details, err := readLockDetails(itemID, item)
+		if err != nil {
+			svc.logger.Printf("[warn][setddblock] skip item_id=%s in table_name=%s: %s", itemID, tableName, err)
+			continue
+		}
+		page.Locks = append(page.Locks, details)
+	}
+	if len(output.LastEvaluatedKey) > 0 {
+		page.NextPageToken, err = encodePageToken(output.LastEvaluatedKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// encodePageToken encodes the key of the last evaluated item, whose key attributes are all strings.
+func encodePageToken(key map[string]types.AttributeValue) (string, error) {
+	attrs := make(map[string]string, len(key))
+	for name, value := range key {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("key attribute %s is not a string", name)
+		}
+		attrs[name] = s.Value
+	}
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodePageToken(token string) (map[string]types.AttributeValue, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	var attrs map[string]string
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	key := make(map[string]types.AttributeValue, len(attrs))
+	for name, value := range attrs {
+		key[name] = &types.AttributeValueMemberS{
+			Value: value,
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,318 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A multi lock reads the items with TransactGetItems and writes them with TransactWriteItems,
+// conditioned on the observed revisions and fencing tokens, so that either every item is written or none.
+
+func (svc *dynamoDBService) AcquireLocks(ctx context.Context, parms []*LockInput) ([]*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] AcquireLocks for %d items at %s", len(parms), time.Now().Format(time.RFC3339))
+	ret, err := svc.tryAcquireLocks(ctx, parms)
+	if !isRetryableAcquireLocksError(err) {
+		return ret, err
+	}
+	retrier := retryPolicy.Start(ctx)
+	for retrier.Continue() {
+		ret, err = svc.tryAcquireLocks(ctx, parms)
+		if !isRetryableAcquireLocksError(err) {
+			return ret, err
+		}
+	}
+	svc.logger.Printf("[error][setddblock] failed to acquire locks after all retries: %s", err)
+	return nil, err
+}
+
+func (svc *dynamoDBService) getItems(ctx context.Context, parms []*LockInput) ([]map[string]types.AttributeValue, error) {
+	gets := make([]types.TransactGetItem, 0, len(parms))
+	for _, p := range parms {
+		gets = append(gets, types.TransactGetItem{
+			Get: &types.Get{
+				TableName: aws.String(p.TableName),
+				Key: map[string]types.AttributeValue{
+					"ID": &types.AttributeValueMemberS{
+						Value: p.ItemID,
+					},
+				},
+			},
+		})
+	}
+	output, err := svc.client.TransactGetItems(ctx, &dynamodb.TransactGetItemsInput{
+		TransactItems: gets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]map[string]types.AttributeValue, len(parms))
+	for i, response := range output.Responses {
+		items[i] = response.Item
+	}
+	return items, nil
+}
+
+func (svc *dynamoDBService) tryAcquireLocks(ctx context.Context, parms []*LockInput) ([]*LockOutput, error) {
+	items, err := svc.getItems(ctx, parms)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	held := false
+	current := make([]*LockOutput, 0, len(parms))
+	granted := make([]*LockOutput, 0, len(parms))
+	updates := make([]types.TransactWriteItem, 0, len(parms))
+	for i, p := range parms {
+		item := items[i]
+		revision, locked := readAttributeValueMemberS(item, "Revision")
+		locked = locked && revision != ""
+		expiry, _ := leaseExpiry(item)
+		n, _ := readAttributeValueMemberN(item, "LeaseDuration")
+		leaseDuration := time.Duration(n) * time.Millisecond
+		if locked && !p.leaseExpired(expiry, now) && (p.PrevRevision == nil || *p.PrevRevision != revision) {
+			svc.logger.Printf("[debug][setddblock] item_id=%s is held by revision=%s", p.ItemID, revision)
+			held = true
+		}
+		current = append(current, &LockOutput{
+			LockGranted:        false,
+			LeaseDuration:      leaseDuration,
+			Revision:           revision,
+			NextHeartbeatLimit: now.Add(leaseDuration).Truncate(time.Millisecond),
+		})
+
+		lockItem, nextHeartbeatLimit := p.item()
+		fencingToken, hasFencingToken := readAttributeValueMemberN(item, "FencingToken")
+		names := map[string]string{
+			"#FencingToken": "FencingToken",
+		}
+		values := map[string]types.AttributeValue{
+			":FencingToken": &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(fencingToken+1, 10),
+			},
+		}
+		updateExpression := setExpression(lockItem, names, values, leaseAttributes, ownerAttributes) + ",#FencingToken=:FencingToken"
+		// the held item must not be deleted by the ttl of a released item.
+		names["#ttl"] = "ttl"
+		removes := []string{"#ttl"}
+		if _, ok := lockItem["Payload"]; !ok {
+			names["#Payload"] = "Payload"
+			removes = append(removes, "#Payload")
+		}
+		updateExpression += " REMOVE " + strings.Join(removes, ",")
+		var conditions []string
+		if hasFencingToken {
+			conditions = append(conditions, "#FencingToken=:PrevFencingToken")
+			values[":PrevFencingToken"] = &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(fencingToken, 10),
+			}
+		} else {
+			conditions = append(conditions, "attribute_not_exists(#FencingToken)")
+		}
+		if locked {
+			conditions = append(conditions, "#Revision=:ObservedRevision")
+			values[":ObservedRevision"] = &types.AttributeValueMemberS{
+				Value: revision,
+			}
+		} else {
+			conditions = append(conditions, "attribute_not_exists(#Revision)")
+		}
+		updates = append(updates, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(p.TableName),
+				Key: map[string]types.AttributeValue{
+					"ID": lockItem["ID"],
+				},
+				UpdateExpression:          aws.String(updateExpression),
+				ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			},
+		})
+		granted = append(granted, &LockOutput{
+			LockGranted:        true,
+			LeaseDuration:      p.LeaseDuration,
+			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+			Revision:           p.Revision,
+			FencingToken:       fencingToken + 1,
+		})
+	}
+	if held {
+		svc.logger.Printf("[debug][setddblock] not locks granted")
+		return current, nil
+	}
+	_, err = svc.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: updates,
+	})
+	if err == nil {
+		svc.logger.Printf("[debug][setddblock] locks granted for %d items", len(parms))
+		return granted, nil
+	}
+	if isTransactionConditionFailed(err) {
+		// someone has written an item after it was read.
+		svc.logger.Printf("[debug][setddblock] acquire locks transaction canceled: %s", err)
+		return nil, errMaybeRaceDeleted
+	}
+	svc.logger.Printf("[warn][setddblock] acquire locks failed: %s", err)
+	return nil, err
+}
+
+// isRetryableAcquireLocksError reports whether tryAcquireLocks should be tried again,
+// because an item was written after it was read, or the transaction was canceled by a transient reason.
+func isRetryableAcquireLocksError(err error) bool {
+	return err == errMaybeRaceDeleted || isTransactionCanceledBy(err, "TransactionConflict", "ThrottlingError", "ProvisionedThroughputExceeded")
+}
+
+func (svc *dynamoDBService) SendHeartbeats(ctx context.Context, parms []*LockInput) ([]*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] SendHeartbeats for %d items", len(parms))
+	for _, p := range parms {
+		if p.PrevRevision == nil {
+			return nil, errors.New("prev revision is must need")
+		}
+	}
+	retrier := retryPolicy.Start(ctx)
+	var err error
+	for retrier.Continue() {
+		// the items are built for each attempt, so that a retry extends the lease from its own time.
+		updates, outputs := heartbeatTransactItems(parms)
+		_, err = svc.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: updates,
+		})
+		if err == nil {
+			return outputs, nil
+		}
+		if isTransactionConditionFailed(err) {
+			return nil, multiHeartbeatError(parms, lockLost(err))
+		}
+		svc.logger.Printf("[warn][setddblock] send heartbeats failed retrying, err=%s", err)
+	}
+	return nil, multiHeartbeatError(parms, err)
+}
+
+// heartbeatTransactItems returns the updates of a heartbeat of the items, and their outputs when it succeeds.
+func heartbeatTransactItems(parms []*LockInput) ([]types.TransactWriteItem, []*LockOutput) {
+	updates := make([]types.TransactWriteItem, 0, len(parms))
+	outputs := make([]*LockOutput, 0, len(parms))
+	for _, p := range parms {
+		item, nextHeartbeatLimit := p.item()
+		names := map[string]string{}
+		values := map[string]types.AttributeValue{
+			":PrevRevision": &types.AttributeValueMemberS{
+				Value: *p.PrevRevision,
+			},
+		}
+		updateExpression := setExpression(item, names, values, leaseAttributes) + clearPayloadExpression(p, names)
+		updates = append(updates, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(p.TableName),
+				Key: map[string]types.AttributeValue{
+					"ID": item["ID"],
+				},
+				UpdateExpression:          aws.String(updateExpression),
+				ConditionExpression:       aws.String("#Revision=:PrevRevision"),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			},
+		})
+		outputs = append(outputs, &LockOutput{
+			LockGranted:        true,
+			LeaseDuration:      p.LeaseDuration,
+			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+			Revision:           p.Revision,
+		})
+	}
+	return updates, outputs
+}
+
+// multiHeartbeatError returns *HeartbeatError for the items, whose ItemID is the comma separated item IDs.
+func multiHeartbeatError(parms []*LockInput, err error) error {
+	itemIDs := make([]string, 0, len(parms))
+	for _, p := range parms {
+		itemIDs = append(itemIDs, p.ItemID)
+	}
+	return &HeartbeatError{
+		TableName: parms[0].TableName,
+		ItemID:    strings.Join(itemIDs, ","),
+		Err:       err,
+	}
+}
+
+func (svc *dynamoDBService) ReleaseLocks(ctx context.Context, parms []*LockInput) error {
+	updates := make([]types.TransactWriteItem, 0, len(parms))
+	for _, p := range parms {
+		if p.PrevRevision == nil {
+			return errors.New("prev revision is must need")
+		}
+		names := map[string]string{}
+		values := map[string]types.AttributeValue{
+			":PrevRevision": &types.AttributeValueMemberS{
+				Value: *p.PrevRevision,
+			},
+		}
+		updates = append(updates, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(p.TableName),
+				Key: map[string]types.AttributeValue{
+					"ID": &types.AttributeValueMemberS{
+						Value: p.ItemID,
+					},
+				},
+				UpdateExpression:          aws.String(svc.releaseExpression(names, values)),
+				ConditionExpression:       aws.String("#Revision=:PrevRevision"),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			},
+		})
+	}
+	retrier := retryPolicy.Start(ctx)
+	var err error
+	for retrier.Continue() {
+		_, err = svc.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: updates,
+		})
+		if err == nil {
+			svc.logger.Printf("[debug][setddblock] success - release %d items", len(parms))
+			return nil
+		}
+		if isTransactionConditionFailed(err) {
+			// some items are no longer ours, release the others one by one.
+			svc.logger.Printf("[warn][setddblock] some items are already taken, release one by one: %s", err)
+			for _, p := range parms {
+				if err := svc.ReleaseLock(ctx, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		svc.logger.Printf("[warn][setddblock] release locks failed retrying, err=%s", err)
+	}
+	return fmt.Errorf("release locks failed: %w", err)
+}
+
+// isTransactionConditionFailed reports whether the transaction was canceled by a failed condition of an item.
+func isTransactionConditionFailed(err error) bool {
+	return isTransactionCanceledBy(err, "ConditionalCheckFailed")
+}
+
+// isTransactionCanceledBy reports whether the transaction was canceled by one of the reason codes for an item.
+func isTransactionCanceledBy(err error, codes ...string) bool {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return false
+	}
+	for _, reason := range canceled.CancellationReasons {
+		for _, code := range codes {
+			if aws.ToString(reason.Code) == code {
+				return true
+			}
+		}
+	}
+	return false
+}
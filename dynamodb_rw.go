@@ -0,0 +1,252 @@
+package setddblock
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The reader-writer lock item has the attributes of an exclusive lock item for the writer,
+// and in addition:
+//
+//	Readers:       map of reader revision to the expiration time of the reader lease in unix milliseconds
+//	WriterWaiting: unix milliseconds until which a waiting writer blocks new readers
+
+func (svc *dynamoDBService) AcquireReadLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] AcquireReadLock %s", parms)
+	if err := svc.ensureHolderMap(ctx, parms, "Readers"); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	nextHeartbeatLimit := now.Add(parms.LeaseDuration)
+	names := map[string]string{
+		"#Readers":       "Readers",
+		"#Reader":        parms.Revision,
+		"#Revision":      "Revision",
+		"#WriterWaiting": "WriterWaiting",
+	}
+	values := map[string]types.AttributeValue{
+		":ReaderExpires": &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(nextHeartbeatLimit.UnixMilli(), 10),
+		},
+	}
+	writerCondition := "attribute_not_exists(#Revision)"
+	if parms.IgnoreExpiry {
+		// the expiry of the writer is not trusted, but the time is still compared with WriterWaiting.
+		values[":NowMillis"] = &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(now.UnixMilli(), 10),
+		}
+	} else {
+		writerCondition += " OR " + expiredCondition(names, values, now)
+	}
+	if parms.PrevRevision != nil {
+		// the writer has not sent a heartbeat since the last attempt, it is considered expired.
+		writerCondition += " OR #Revision = :PrevRevision"
+		values[":PrevRevision"] = &types.AttributeValueMemberS{
+			Value: *parms.PrevRevision,
+		}
+	}
+	output, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: parms.ItemID,
+			},
+		},
+		// the item is released by the writer, and is kept for the released item retention after the end of the reader lease.
+		UpdateExpression:          aws.String(svc.releaseExpressionAt(nextHeartbeatLimit, names, values, "#Readers.#Reader = :ReaderExpires")),
+		ConditionExpression:       aws.String("(" + writerCondition + ") AND (attribute_not_exists(#WriterWaiting) OR #WriterWaiting < :NowMillis)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err == nil {
+		fencingToken, _ := readAttributeValueMemberN(output.Attributes, "FencingToken")
+		svc.logger.Printf("[debug][setddblock] read lock granted")
+		return &LockOutput{
+			LockGranted:        true,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+			Revision:           parms.Revision,
+			FencingToken:       fencingToken,
+		}, nil
+	}
+	if !isConditionalCheckFailed(err) {
+		return nil, err
+	}
+	svc.logger.Printf("[debug][setddblock] not read lock granted")
+	item, err := svc.getItem(ctx, parms.TableName, parms.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	leaseDuration := parms.LeaseDuration
+	if n, ok := readAttributeValueMemberN(item, "LeaseDuration"); ok {
+		leaseDuration = time.Duration(n) * time.Millisecond
+	}
+	revision, _ := readAttributeValueMemberS(item, "Revision")
+	fencingToken, _ := readAttributeValueMemberN(item, "FencingToken")
+	return &LockOutput{
+		LockGranted:        false,
+		LeaseDuration:      leaseDuration,
+		Revision:           revision,
+		NextHeartbeatLimit: time.Now().Add(leaseDuration).Truncate(time.Millisecond),
+		FencingToken:       fencingToken,
+	}, nil
+}
+
+func (svc *dynamoDBService) SendReadHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] SendReadHeartbeat %s", parms)
+	return svc.sendHolderHeartbeat(ctx, parms, "Readers", svc.releasedRetention > 0)
+}
+
+func (svc *dynamoDBService) ReleaseReadLock(ctx context.Context, parms *LockInput) error {
+	return svc.releaseHolder(ctx, parms, "Readers")
+}
+
+func (svc *dynamoDBService) AcquireWriteLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] AcquireWriteLock %s", parms)
+	ret, err := svc.tryAcquireWriteLock(ctx, parms)
+	if err != errMaybeRaceDeleted {
+		return ret, err
+	}
+	retrier := retryPolicy.Start(ctx)
+	for retrier.Continue() {
+		ret, err = svc.tryAcquireWriteLock(ctx, parms)
+		if err != errMaybeRaceDeleted {
+			return ret, err
+		}
+	}
+	svc.logger.Printf("[error][setddblock] failed to acquire write lock after all retries: %s", err)
+	return nil, err
+}
+
+// tryAcquireWriteLock reads the item and acquires the write lock with a condition that nothing has changed since the read.
+// Expired reader leases are removed in the same write.
+func (svc *dynamoDBService) tryAcquireWriteLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	item, err := svc.getItem(ctx, parms.TableName, parms.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	fencingToken, _ := readAttributeValueMemberN(item, "FencingToken")
+	names := map[string]string{
+		"#WriterWaiting": "WriterWaiting",
+		"#Readers":       "Readers",
+	}
+	lockItem, nextHeartbeatLimit := parms.item()
+	values := map[string]types.AttributeValue{}
+	conditions := []string{"attribute_not_exists(#Revision)"}
+	if revision, ok := readAttributeValueMemberS(item, "Revision"); ok {
+		expiry, _ := leaseExpiry(item)
+		if !parms.leaseExpired(expiry, now) && (parms.PrevRevision == nil || *parms.PrevRevision != revision) {
+			n, _ := readAttributeValueMemberN(item, "LeaseDuration")
+			leaseDuration := time.Duration(n) * time.Millisecond
+			if parms.Wait {
+				svc.markWriterWaiting(ctx, parms, now.Add(leaseDuration))
+			}
+			svc.logger.Printf("[debug][setddblock] not write lock granted, held by writer %s", revision)
+			return &LockOutput{
+				LockGranted:        false,
+				LeaseDuration:      leaseDuration,
+				Revision:           revision,
+				NextHeartbeatLimit: now.Add(leaseDuration).Truncate(time.Millisecond),
+				FencingToken:       fencingToken,
+			}, nil
+		}
+		// the writer is expired, take over only if it is still the same writer.
+		conditions = []string{"#Revision = :ObservedRevision"}
+		values[":ObservedRevision"] = &types.AttributeValueMemberS{
+			Value: revision,
+		}
+	}
+
+	live, expired, exists := readHolderLeases(item, "Readers", now)
+	waitUntil := now.Add(parms.LeaseDuration)
+	for _, lease := range live {
+		if lease.expires.Before(waitUntil) {
+			waitUntil = lease.expires
+		}
+	}
+	removes, readerConditions := removeExpiredHolders("Readers", expired, names, values)
+	if exists {
+		values[":ReaderCount"] = &types.AttributeValueMemberN{
+			Value: strconv.Itoa(len(expired)),
+		}
+		readerConditions = append(readerConditions, "size(#Readers) = :ReaderCount")
+	} else {
+		readerConditions = append(readerConditions, "attribute_not_exists(#Readers)")
+	}
+	if len(live) > 0 {
+		if parms.Wait {
+			svc.markWriterWaiting(ctx, parms, waitUntil)
+		}
+		svc.logger.Printf("[debug][setddblock] not write lock granted, %d readers hold the lock", len(live))
+		return &LockOutput{
+			LockGranted:        false,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: waitUntil.Truncate(time.Millisecond),
+			FencingToken:       fencingToken,
+		}, nil
+	}
+	updateExpression := acquireExpression(lockItem, names, values, append([]string{"#WriterWaiting"}, removes...)...)
+	conditions = append(conditions, readerConditions...)
+	output, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": lockItem["ID"],
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			svc.logger.Printf("[debug][setddblock] lock item changed while acquiring write lock")
+			return nil, errMaybeRaceDeleted
+		}
+		return nil, err
+	}
+	fencingToken, _ = readAttributeValueMemberN(output.Attributes, "FencingToken")
+	svc.logger.Printf("[debug][setddblock] write lock granted, fencing_token: %d", fencingToken)
+	return &LockOutput{
+		LockGranted:        true,
+		LeaseDuration:      parms.LeaseDuration,
+		NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+		Revision:           parms.Revision,
+		FencingToken:       fencingToken,
+	}, nil
+}
+
+// markWriterWaiting blocks new readers until the next acquisition attempt of the waiting writer.
+// It is called only for a writer that retries, and never creates a lock item.
+func (svc *dynamoDBService) markWriterWaiting(ctx context.Context, parms *LockInput, nextAttempt time.Time) {
+	_, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: parms.ItemID,
+			},
+		},
+		UpdateExpression:    aws.String("SET #WriterWaiting = :WriterWaiting"),
+		ConditionExpression: aws.String("attribute_exists(#ID)"),
+		ExpressionAttributeNames: map[string]string{
+			"#WriterWaiting": "WriterWaiting",
+			"#ID":            "ID",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":WriterWaiting": &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(nextAttempt.Add(parms.LeaseDuration).UnixMilli(), 10),
+			},
+		},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		svc.logger.Printf("[warn][setddblock] failed to mark writer waiting: %s", err)
+	}
+}
@@ -0,0 +1,324 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Schema is the key schema and attribute names of the lock table used by the DynamoDB backend.
+// It lets setddblock store locks in an existing table, e.g. a single-table design with a PK/SK schema and its own TTL attribute.
+// Empty fields are the defaults of DefaultSchema.
+type Schema struct {
+	// PartitionKey is the name of the partition key, whose value is PartitionKeyPrefix followed by the item ID.
+	PartitionKey       string
+	PartitionKeyPrefix string
+	// SortKey is the name of the optional sort key.
+	// Its value is SortKeyPrefix followed by the item ID if SortKeyPrefix is set, otherwise SortKeyValue.
+	SortKey       string
+	SortKeyValue  string
+	SortKeyPrefix string
+
+	// The names of the lock attributes.
+	Revision              string
+	LeaseDuration         string
+	Expires               string
+	TTL                   string
+	FencingToken          string
+	OwnerName             string
+	OwnerHostname         string
+	OwnerPID              string
+	OwnerProcessStartTime string
+	AcquiredAt            string
+	Payload               string
+	Readers               string
+	Holders               string
+	WriterWaiting         string
+	Broken                string
+}
+
+// DefaultSchema returns the schema of the lock table created by setddblock.
+func DefaultSchema() Schema {
+	return Schema{
+		PartitionKey:          "ID",
+		Revision:              "Revision",
+		LeaseDuration:         "LeaseDuration",
+		Expires:               "Expires",
+		TTL:                   "ttl",
+		FencingToken:          "FencingToken",
+		OwnerName:             "OwnerName",
+		OwnerHostname:         "OwnerHostname",
+		OwnerPID:              "OwnerPID",
+		OwnerProcessStartTime: "OwnerProcessStartTime",
+		AcquiredAt:            "AcquiredAt",
+		Payload:               "Payload",
+		Readers:               "Readers",
+		Holders:               "Holders",
+		WriterWaiting:         "WriterWaiting",
+		Broken:                "Broken",
+	}
+}
+
+// attributes returns the attribute names of the schema by the names of DefaultSchema.
+// The sort key is not included, because it has no counterpart in the default schema.
+func (s Schema) attributes() map[string]string {
+	return map[string]string{
+		"ID":                    s.PartitionKey,
+		"Revision":              s.Revision,
+		"LeaseDuration":         s.LeaseDuration,
+		"Expires":               s.Expires,
+		"ttl":                   s.TTL,
+		"FencingToken":          s.FencingToken,
+		"OwnerName":             s.OwnerName,
+		"OwnerHostname":         s.OwnerHostname,
+		"OwnerPID":              s.OwnerPID,
+		"OwnerProcessStartTime": s.OwnerProcessStartTime,
+		"AcquiredAt":            s.AcquiredAt,
+		"Payload":               s.Payload,
+		"Readers":               s.Readers,
+		"Holders":               s.Holders,
+		"WriterWaiting":         s.WriterWaiting,
+		"Broken":                s.Broken,
+	}
+}
+
+// withDefaults returns the schema whose empty names are replaced by the names of DefaultSchema.
+func (s Schema) withDefaults() Schema {
+	d := DefaultSchema()
+	for _, f := range []struct{ field, def *string }{
+		{&s.PartitionKey, &d.PartitionKey},
+		{&s.Revision, &d.Revision},
+		{&s.LeaseDuration, &d.LeaseDuration},
+		{&s.Expires, &d.Expires},
+		{&s.TTL, &d.TTL},
+		{&s.FencingToken, &d.FencingToken},
+		{&s.OwnerName, &d.OwnerName},
+		{&s.OwnerHostname, &d.OwnerHostname},
+		{&s.OwnerPID, &d.OwnerPID},
+		{&s.OwnerProcessStartTime, &d.OwnerProcessStartTime},
+		{&s.AcquiredAt, &d.AcquiredAt},
+		{&s.Payload, &d.Payload},
+		{&s.Readers, &d.Readers},
+		{&s.Holders, &d.Holders},
+		{&s.WriterWaiting, &d.WriterWaiting},
+		{&s.Broken, &d.Broken},
+	} {
+		if *f.field == "" {
+			*f.field = *f.def
+		}
+	}
+	return s
+}
+
+func (s Schema) validate() error {
+	if s.SortKey == "" && (s.SortKeyValue != "" || s.SortKeyPrefix != "") {
+		return fmt.Errorf("schema: sort key value is set without sort key name")
+	}
+	if s.SortKey != "" && s.SortKeyValue == "" && s.SortKeyPrefix == "" {
+		return fmt.Errorf("schema: sort key %s needs a value or a prefix", s.SortKey)
+	}
+	seen := map[string]bool{}
+	if s.SortKey != "" {
+		seen[s.SortKey] = true
+	}
+	for _, name := range s.attributes() {
+		if seen[name] {
+			return fmt.Errorf("schema: attribute name %s is used twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// schemaClient translates the requests of dynamoDBService, which are written in the names of DefaultSchema, into the schema.
+// Items read from the table are translated back, and attributes outside of the schema are dropped.
+type schemaClient struct {
+	DynamoDBAPI
+	schema     Schema
+	attributes map[string]string
+}
+
+func newSchemaClient(client DynamoDBAPI, schema Schema) *schemaClient {
+	return &schemaClient{
+		DynamoDBAPI: client,
+		schema:      schema,
+		attributes:  schema.attributes(),
+	}
+}
+
+func (c *schemaClient) key(key map[string]types.AttributeValue) map[string]types.AttributeValue {
+	id, _ := readAttributeValueMemberS(key, "ID")
+	translated := map[string]types.AttributeValue{
+		c.schema.PartitionKey: &types.AttributeValueMemberS{
+			Value: c.schema.PartitionKeyPrefix + id,
+		},
+	}
+	if c.schema.SortKey != "" {
+		value := c.schema.SortKeyValue
+		if c.schema.SortKeyPrefix != "" {
+			value = c.schema.SortKeyPrefix + id
+		}
+		translated[c.schema.SortKey] = &types.AttributeValueMemberS{
+			Value: value,
+		}
+	}
+	return translated
+}
+
+func (c *schemaClient) names(names map[string]string) map[string]string {
+	if names == nil {
+		return nil
+	}
+	translated := make(map[string]string, len(names))
+	for placeholder, name := range names {
+		if attr, ok := c.attributes[name]; ok {
+			name = attr
+		}
+		translated[placeholder] = name
+	}
+	return translated
+}
+
+func (c *schemaClient) item(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	translated := make(map[string]types.AttributeValue, len(c.attributes))
+	for name, attr := range c.attributes {
+		if v, ok := item[attr]; ok {
+			translated[name] = v
+		}
+	}
+	if id, ok := readAttributeValueMemberS(translated, "ID"); ok {
+		translated["ID"] = &types.AttributeValueMemberS{
+			Value: strings.TrimPrefix(id, c.schema.PartitionKeyPrefix),
+		}
+	}
+	return translated
+}
+
+func (c *schemaClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	input := *params
+	input.Key = c.key(params.Key)
+	input.ExpressionAttributeNames = c.names(params.ExpressionAttributeNames)
+	output, err := c.DynamoDBAPI.GetItem(ctx, &input, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	output.Item = c.item(output.Item)
+	return output, nil
+}
+
+func (c *schemaClient) update(update *types.Update) *types.Update {
+	translated := *update
+	translated.Key = c.key(update.Key)
+	translated.ExpressionAttributeNames = c.names(update.ExpressionAttributeNames)
+	return &translated
+}
+
+func (c *schemaClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	input := *params
+	input.Key = c.key(params.Key)
+	input.ExpressionAttributeNames = c.names(params.ExpressionAttributeNames)
+	output, err := c.DynamoDBAPI.UpdateItem(ctx, &input, optFns...)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			condErr.Item = c.item(condErr.Item)
+		}
+		return nil, err
+	}
+	output.Attributes = c.item(output.Attributes)
+	return output, nil
+}
+
+func (c *schemaClient) TransactGetItems(ctx context.Context, params *dynamodb.TransactGetItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error) {
+	input := *params
+	input.TransactItems = make([]types.TransactGetItem, 0, len(params.TransactItems))
+	for _, item := range params.TransactItems {
+		if item.Get != nil {
+			get := *item.Get
+			get.Key = c.key(item.Get.Key)
+			get.ExpressionAttributeNames = c.names(item.Get.ExpressionAttributeNames)
+			item.Get = &get
+		}
+		input.TransactItems = append(input.TransactItems, item)
+	}
+	output, err := c.DynamoDBAPI.TransactGetItems(ctx, &input, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range output.Responses {
+		output.Responses[i].Item = c.item(output.Responses[i].Item)
+	}
+	return output, nil
+}
+
+func (c *schemaClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	input := *params
+	input.TransactItems = make([]types.TransactWriteItem, 0, len(params.TransactItems))
+	for _, item := range params.TransactItems {
+		if item.Update != nil {
+			item.Update = c.update(item.Update)
+		}
+		input.TransactItems = append(input.TransactItems, item)
+	}
+	return c.DynamoDBAPI.TransactWriteItems(ctx, &input, optFns...)
+}
+
+// Scan translates the names of the filter and the items read.
+// ExclusiveStartKey and LastEvaluatedKey are kept in the key schema of the table, since they are opaque to dynamoDBService.
+func (c *schemaClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	input := *params
+	input.ExpressionAttributeNames = c.names(params.ExpressionAttributeNames)
+	output, err := c.DynamoDBAPI.Scan(ctx, &input, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range output.Items {
+		output.Items[i] = c.item(output.Items[i])
+	}
+	return output, nil
+}
+
+func (c *schemaClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	input := *params
+	input.AttributeDefinitions = []types.AttributeDefinition{
+		{
+			AttributeName: aws.String(c.schema.PartitionKey),
+			AttributeType: types.ScalarAttributeTypeS,
+		},
+	}
+	input.KeySchema = []types.KeySchemaElement{
+		{
+			AttributeName: aws.String(c.schema.PartitionKey),
+			KeyType:       types.KeyTypeHash,
+		},
+	}
+	if c.schema.SortKey != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(c.schema.SortKey),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+		input.KeySchema = append(input.KeySchema, types.KeySchemaElement{
+			AttributeName: aws.String(c.schema.SortKey),
+			KeyType:       types.KeyTypeRange,
+		})
+	}
+	return c.DynamoDBAPI.CreateTable(ctx, &input, optFns...)
+}
+
+func (c *schemaClient) UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	input := *params
+	if params.TimeToLiveSpecification != nil {
+		spec := *params.TimeToLiveSpecification
+		spec.AttributeName = aws.String(c.schema.TTL)
+		input.TimeToLiveSpecification = &spec
+	}
+	return c.DynamoDBAPI.UpdateTimeToLive(ctx, &input, optFns...)
+}
@@ -0,0 +1,78 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/stretchr/testify/require"
+)
+
+type schemaStubDynamoDB struct {
+	stubDynamoDB
+	updates []*dynamodb.UpdateItemInput
+}
+
+func (c *schemaStubDynamoDB) UpdateItem(_ context.Context, params *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.updates = append(c.updates, params)
+	return &dynamodb.UpdateItemOutput{
+		Attributes: map[string]types.AttributeValue{
+			"lock_fencing_token": &types.AttributeValueMemberN{Value: "3"},
+			"FencingToken":       &types.AttributeValueMemberN{Value: "100"},
+		},
+	}, nil
+}
+
+func TestWithSchema(t *testing.T) {
+	client := &schemaStubDynamoDB{}
+	locker, err := setddblock.New(
+		"ddb://single_table/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithSchema(setddblock.Schema{
+			PartitionKey:       "PK",
+			PartitionKeyPrefix: "LOCK#",
+			SortKey:            "SK",
+			SortKeyValue:       "LOCK",
+			Revision:           "lock_revision",
+			TTL:                "expires_at",
+			FencingToken:       "lock_fencing_token",
+		}),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.EqualValues(t, 3, locker.FencingToken(), "the attributes outside of the schema are ignored")
+	require.NoError(t, locker.UnlockWithErr(ctx))
+
+	require.Len(t, client.updates, 2)
+	for _, update := range client.updates {
+		require.Equal(t, map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "LOCK#item1"},
+			"SK": &types.AttributeValueMemberS{Value: "LOCK"},
+		}, update.Key)
+		require.Equal(t, "lock_revision", update.ExpressionAttributeNames["#Revision"])
+		require.Equal(t, "expires_at", update.ExpressionAttributeNames["#ttl"])
+	}
+	require.Equal(t, "lock_fencing_token", client.updates[0].ExpressionAttributeNames["#FencingToken"])
+	require.Equal(t, "LeaseDuration", client.updates[0].ExpressionAttributeNames["#LeaseDuration"], "empty names are the defaults")
+}
+
+func TestWithSchemaValidation(t *testing.T) {
+	for _, schema := range []setddblock.Schema{
+		{SortKeyValue: "LOCK"},
+		{SortKey: "SK"},
+		{Revision: "ttl"},
+	} {
+		_, err := setddblock.New(
+			"ddb://single_table/item1",
+			setddblock.WithDynamoDBClient(&stubDynamoDB{}),
+			setddblock.WithSchema(schema),
+		)
+		require.Error(t, err, "%+v", schema)
+	}
+}
@@ -0,0 +1,118 @@
+package setddblock
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The semaphore item keeps the permit holders in the Holders map attribute.
+
+func (svc *dynamoDBService) AcquirePermit(ctx context.Context, parms *LockInput, permits int) (*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] AcquirePermit %s, permits=%d", parms, permits)
+	if err := svc.ensureHolderMap(ctx, parms, "Holders"); err != nil {
+		return nil, err
+	}
+	ret, err := svc.tryAcquirePermit(ctx, parms, permits)
+	if err != errMaybeRaceDeleted {
+		return ret, err
+	}
+	retrier := retryPolicy.Start(ctx)
+	for retrier.Continue() {
+		ret, err = svc.tryAcquirePermit(ctx, parms, permits)
+		if err != errMaybeRaceDeleted {
+			return ret, err
+		}
+	}
+	svc.logger.Printf("[error][setddblock] failed to acquire permit after all retries: %s", err)
+	return nil, err
+}
+
+// tryAcquirePermit adds the holder lease if fewer than permits live leases remain after removing the expired ones.
+func (svc *dynamoDBService) tryAcquirePermit(ctx context.Context, parms *LockInput, permits int) (*LockOutput, error) {
+	item, err := svc.getItem(ctx, parms.TableName, parms.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	live, expired, _ := readHolderLeases(item, "Holders", now)
+	if len(live) >= permits {
+		waitUntil := now.Add(parms.LeaseDuration)
+		for _, lease := range live {
+			if lease.expires.Before(waitUntil) {
+				waitUntil = lease.expires
+			}
+		}
+		svc.logger.Printf("[debug][setddblock] not permit granted, %d holders hold the permits", len(live))
+		return &LockOutput{
+			LockGranted:        false,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: waitUntil.Truncate(time.Millisecond),
+		}, nil
+	}
+	nextHeartbeatLimit := now.Add(parms.LeaseDuration)
+	names := map[string]string{
+		"#Holders":      "Holders",
+		"#Holder":       parms.Revision,
+		"#FencingToken": "FencingToken",
+	}
+	values := map[string]types.AttributeValue{
+		":Expires": &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(nextHeartbeatLimit.UnixMilli(), 10),
+		},
+		":One": &types.AttributeValueMemberN{Value: "1"},
+		":Limit": &types.AttributeValueMemberN{
+			Value: strconv.Itoa(permits + len(expired)),
+		},
+	}
+	removes, conditions := removeExpiredHolders("Holders", expired, names, values)
+	conditions = append(conditions, "size(#Holders) < :Limit")
+	// every permit acquisition increments the fencing token, like an acquisition of a lock.
+	updateExpression := "SET #Holders.#Holder = :Expires ADD #FencingToken :One"
+	if len(removes) > 0 {
+		updateExpression += " REMOVE " + strings.Join(removes, ",")
+	}
+	output, err := svc.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &parms.TableName,
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{
+				Value: parms.ItemID,
+			},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			svc.logger.Printf("[debug][setddblock] semaphore item changed while acquiring permit")
+			return nil, errMaybeRaceDeleted
+		}
+		return nil, err
+	}
+	fencingToken, _ := readAttributeValueMemberN(output.Attributes, "FencingToken")
+	svc.logger.Printf("[debug][setddblock] permit granted")
+	return &LockOutput{
+		LockGranted:        true,
+		LeaseDuration:      parms.LeaseDuration,
+		NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+		Revision:           parms.Revision,
+		FencingToken:       fencingToken,
+	}, nil
+}
+
+func (svc *dynamoDBService) SendPermitHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	svc.logger.Printf("[debug][setddblock] SendPermitHeartbeat %s", parms)
+	return svc.sendHolderHeartbeat(ctx, parms, "Holders", false)
+}
+
+func (svc *dynamoDBService) ReleasePermit(ctx context.Context, parms *LockInput) error {
+	return svc.releaseHolder(ctx, parms, "Holders")
+}
@@ -0,0 +1,148 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/stretchr/testify/require"
+)
+
+// tableStubDynamoDB has no table until CreateTable is called.
+type tableStubDynamoDB struct {
+	stubDynamoDB
+	created       *dynamodb.CreateTableInput
+	activeAfter   int
+	describeCalls int
+}
+
+func (c *tableStubDynamoDB) DescribeTable(_ context.Context, params *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	c.record("DescribeTable")
+	if c.created == nil {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("table not found")}
+	}
+	c.describeCalls++
+	status := types.TableStatusCreating
+	if c.describeCalls > c.activeAfter {
+		status = types.TableStatusActive
+	}
+	return &dynamodb.DescribeTableOutput{
+		Table: &types.TableDescription{
+			TableName:   params.TableName,
+			TableStatus: status,
+		},
+	}, nil
+}
+
+func (c *tableStubDynamoDB) CreateTable(_ context.Context, params *dynamodb.CreateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	c.record("CreateTable")
+	c.created = params
+	return &dynamodb.CreateTableOutput{
+		TableDescription: &types.TableDescription{
+			TableArn: aws.String("arn:aws:dynamodb:ap-northeast-1:123456789012:table/" + *params.TableName),
+		},
+	}, nil
+}
+
+func (c *tableStubDynamoDB) UpdateTimeToLive(_ context.Context, _ *dynamodb.UpdateTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	c.record("UpdateTimeToLive")
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (c *tableStubDynamoDB) UpdateContinuousBackups(_ context.Context, _ *dynamodb.UpdateContinuousBackupsInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	c.record("UpdateContinuousBackups")
+	return &dynamodb.UpdateContinuousBackupsOutput{}, nil
+}
+
+func TestWithAutoCreateTableDisabled(t *testing.T) {
+	client := &tableStubDynamoDB{}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithAutoCreateTable(false),
+	)
+	require.NoError(t, err)
+	_, err = locker.LockWithErr(context.Background())
+	require.ErrorIs(t, err, setddblock.ErrTableNotFound)
+	require.Equal(t, []string{"DescribeTable"}, client.operations)
+}
+
+func TestTableProvisioningOptions(t *testing.T) {
+	client := &tableStubDynamoDB{}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithProvisionedThroughput(5, 10),
+		setddblock.WithTableTags(map[string]string{"team": "batch", "env": "prod"}),
+		setddblock.WithTableSSE("alias/locks"),
+		setddblock.WithPointInTimeRecovery(),
+		setddblock.WithDeletionProtection(),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, locker.UnlockWithErr(ctx))
+
+	require.Equal(t, types.BillingModeProvisioned, client.created.BillingMode)
+	require.EqualValues(t, 5, *client.created.ProvisionedThroughput.ReadCapacityUnits)
+	require.EqualValues(t, 10, *client.created.ProvisionedThroughput.WriteCapacityUnits)
+	require.Equal(t, []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("team"), Value: aws.String("batch")},
+	}, client.created.Tags)
+	require.Equal(t, types.SSETypeKms, client.created.SSESpecification.SSEType)
+	require.Equal(t, "alias/locks", *client.created.SSESpecification.KMSMasterKeyId)
+	require.True(t, *client.created.DeletionProtectionEnabled)
+	require.Equal(t, []string{
+		"DescribeTable",
+		"CreateTable",
+		"DescribeTable",
+		"UpdateTimeToLive",
+		"UpdateContinuousBackups",
+		"UpdateItem attribute_not_exists(#Revision)",
+		"UpdateItem attribute_exists(#Revision) AND #Revision=:PrevRevision",
+	}, client.operations)
+}
+
+func TestWithTableActiveTimeout(t *testing.T) {
+	client := &tableStubDynamoDB{activeAfter: 1000}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithTableActiveTimeout(500*time.Millisecond),
+	)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = locker.LockWithErr(context.Background())
+	require.ErrorIs(t, err, setddblock.ErrTableNotActive)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestWithTableActiveTimeoutValidation(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		_, err := setddblock.New(
+			"ddb://test/item1",
+			setddblock.WithDynamoDBClient(&tableStubDynamoDB{}),
+			setddblock.WithTableActiveTimeout(d),
+		)
+		require.Error(t, err, "%s", d)
+	}
+}
+
+func TestWithProvisionedThroughputValidation(t *testing.T) {
+	for _, units := range [][2]int64{{0, 0}, {5, 0}, {0, 10}, {-1, 10}, {5, -1}} {
+		_, err := setddblock.New(
+			"ddb://test/item1",
+			setddblock.WithDynamoDBClient(&tableStubDynamoDB{}),
+			setddblock.WithProvisionedThroughput(units[0], units[1]),
+		)
+		require.Error(t, err, "%v", units)
+	}
+}
@@ -0,0 +1,121 @@
+package setddblock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// KeySchemaError is returned by the table validation when a key of the lock table does not match the Schema.
+type KeySchemaError struct {
+	TableName string
+	// KeyType is HASH for the partition key or RANGE for the sort key.
+	KeyType string
+	// ExpectedName is empty if the key must not exist, and ActualName is empty if the key does not exist.
+	ExpectedName string
+	ExpectedType string
+	ActualName   string
+	ActualType   string
+}
+
+func (e *KeySchemaError) Error() string {
+	expected := "none"
+	if e.ExpectedName != "" {
+		expected = fmt.Sprintf("%s (%s)", e.ExpectedName, e.ExpectedType)
+	}
+	actual := "none"
+	if e.ActualName != "" {
+		actual = fmt.Sprintf("%s (%s)", e.ActualName, e.ActualType)
+	}
+	return fmt.Sprintf("lock table %s: %s key is %s, expected %s", e.TableName, e.KeyType, actual, expected)
+}
+
+// TTLError is returned by the table validation when TTL of the lock table is not enabled on the TTL attribute of the Schema,
+// so that the items of crashed holders would never be removed.
+type TTLError struct {
+	TableName         string
+	ExpectedAttribute string
+	// ActualAttribute is empty if TTL has never been enabled.
+	ActualAttribute string
+	Status          string
+}
+
+func (e *TTLError) Error() string {
+	return fmt.Sprintf("lock table %s: TTL is %s on attribute %q, expected enabled on %q", e.TableName, e.Status, e.ActualAttribute, e.ExpectedAttribute)
+}
+
+// validateLockTable checks the key schema and the TTL setting of the table.
+func (svc *dynamoDBService) validateLockTable(ctx context.Context, table *types.TableDescription) error {
+	tableName := aws.ToString(table.TableName)
+	attributeTypes := make(map[string]string, len(table.AttributeDefinitions))
+	for _, def := range table.AttributeDefinitions {
+		attributeTypes[aws.ToString(def.AttributeName)] = string(def.AttributeType)
+	}
+	for _, expected := range []struct {
+		keyType types.KeyType
+		name    string
+	}{
+		{types.KeyTypeHash, svc.schema.PartitionKey},
+		{types.KeyTypeRange, svc.schema.SortKey},
+	} {
+		var actual string
+		for _, key := range table.KeySchema {
+			if key.KeyType == expected.keyType {
+				actual = aws.ToString(key.AttributeName)
+			}
+		}
+		if expected.name == "" && actual == "" {
+			continue
+		}
+		if actual != expected.name || attributeTypes[actual] != string(types.ScalarAttributeTypeS) {
+			e := &KeySchemaError{
+				TableName:    tableName,
+				KeyType:      string(expected.keyType),
+				ExpectedName: expected.name,
+				ActualName:   actual,
+				ActualType:   attributeTypes[actual],
+			}
+			if expected.name != "" {
+				e.ExpectedType = string(types.ScalarAttributeTypeS)
+			}
+			return e
+		}
+	}
+
+	output, err := svc.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: &tableName,
+	})
+	if err != nil {
+		return err
+	}
+	status := types.TimeToLiveStatusDisabled
+	var attribute string
+	if output.TimeToLiveDescription != nil {
+		status = output.TimeToLiveDescription.TimeToLiveStatus
+		attribute = aws.ToString(output.TimeToLiveDescription.AttributeName)
+	}
+	svc.logger.Printf("[debug][setddblock] TTL of table `%s` is %s on `%s`", tableName, status, attribute)
+	if (status == types.TimeToLiveStatusEnabled || status == types.TimeToLiveStatusEnabling) && attribute == svc.schema.TTL {
+		return nil
+	}
+	if status == types.TimeToLiveStatusDisabled && svc.repairTTL {
+		svc.logger.Printf("[warn][setddblock] TTL is disabled, enable TTL of table `%s` on `%s`", tableName, svc.schema.TTL)
+		_, err := svc.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+			TableName: &tableName,
+			TimeToLiveSpecification: &types.TimeToLiveSpecification{
+				AttributeName: aws.String("ttl"),
+				Enabled:       aws.Bool(true),
+			},
+		})
+		return err
+	}
+	return &TTLError{
+		TableName:         tableName,
+		ExpectedAttribute: svc.schema.TTL,
+		ActualAttribute:   attribute,
+		Status:            string(status),
+	}
+}
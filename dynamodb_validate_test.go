@@ -0,0 +1,123 @@
+package setddblock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/stretchr/testify/require"
+)
+
+// existingTableStubDynamoDB describes an existing table with the given key schema and TTL setting.
+type existingTableStubDynamoDB struct {
+	stubDynamoDB
+	keySchema      []types.KeySchemaElement
+	attributes     []types.AttributeDefinition
+	ttl            *types.TimeToLiveDescription
+	ttlUpdateInput *dynamodb.UpdateTimeToLiveInput
+}
+
+func (c *existingTableStubDynamoDB) DescribeTable(_ context.Context, params *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	c.record("DescribeTable")
+	return &dynamodb.DescribeTableOutput{
+		Table: &types.TableDescription{
+			TableName:            params.TableName,
+			TableStatus:          types.TableStatusActive,
+			KeySchema:            c.keySchema,
+			AttributeDefinitions: c.attributes,
+		},
+	}, nil
+}
+
+func (c *existingTableStubDynamoDB) DescribeTimeToLive(_ context.Context, _ *dynamodb.DescribeTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	c.record("DescribeTimeToLive")
+	return &dynamodb.DescribeTimeToLiveOutput{
+		TimeToLiveDescription: c.ttl,
+	}, nil
+}
+
+func (c *existingTableStubDynamoDB) UpdateTimeToLive(_ context.Context, params *dynamodb.UpdateTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	c.record("UpdateTimeToLive")
+	c.ttlUpdateInput = params
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func hashKeyTable(name string, attributeType types.ScalarAttributeType) ([]types.KeySchemaElement, []types.AttributeDefinition) {
+	return []types.KeySchemaElement{
+		{AttributeName: aws.String(name), KeyType: types.KeyTypeHash},
+	}, []types.AttributeDefinition{
+		{AttributeName: aws.String(name), AttributeType: attributeType},
+	}
+}
+
+func TestTableValidation(t *testing.T) {
+	enabled := &types.TimeToLiveDescription{
+		AttributeName:    aws.String("ttl"),
+		TimeToLiveStatus: types.TimeToLiveStatusEnabled,
+	}
+	cases := []struct {
+		name      string
+		key       string
+		keyType   types.ScalarAttributeType
+		ttl       *types.TimeToLiveDescription
+		expectErr interface{}
+	}{
+		{name: "valid", key: "ID", keyType: types.ScalarAttributeTypeS, ttl: enabled},
+		{name: "wrong key name", key: "PK", keyType: types.ScalarAttributeTypeS, ttl: enabled, expectErr: &setddblock.KeySchemaError{}},
+		{name: "wrong key type", key: "ID", keyType: types.ScalarAttributeTypeN, ttl: enabled, expectErr: &setddblock.KeySchemaError{}},
+		{name: "ttl disabled", key: "ID", keyType: types.ScalarAttributeTypeS, ttl: &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}, expectErr: &setddblock.TTLError{}},
+		{name: "ttl on other attribute", key: "ID", keyType: types.ScalarAttributeTypeS, ttl: &types.TimeToLiveDescription{AttributeName: aws.String("expires_at"), TimeToLiveStatus: types.TimeToLiveStatusEnabled}, expectErr: &setddblock.TTLError{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &existingTableStubDynamoDB{ttl: c.ttl}
+			client.keySchema, client.attributes = hashKeyTable(c.key, c.keyType)
+			locker, err := setddblock.New(
+				"ddb://test/item1",
+				setddblock.WithDynamoDBClient(client),
+				setddblock.WithDelay(false),
+				setddblock.WithTableValidation(false),
+			)
+			require.NoError(t, err)
+			granted, err := locker.LockWithErr(context.Background())
+			switch expected := c.expectErr.(type) {
+			case *setddblock.KeySchemaError:
+				require.True(t, errors.As(err, &expected), "%v", err)
+				require.Equal(t, "test", expected.TableName)
+			case *setddblock.TTLError:
+				require.True(t, errors.As(err, &expected), "%v", err)
+				require.Equal(t, "ttl", expected.ExpectedAttribute)
+			default:
+				require.NoError(t, err)
+				require.True(t, granted)
+				require.NoError(t, locker.UnlockWithErr(context.Background()))
+			}
+		})
+	}
+}
+
+func TestTableValidationRepairTTL(t *testing.T) {
+	client := &existingTableStubDynamoDB{
+		ttl: &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled},
+	}
+	client.keySchema, client.attributes = hashKeyTable("PK", types.ScalarAttributeTypeS)
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithSchema(setddblock.Schema{PartitionKey: "PK", TTL: "expires_at"}),
+		setddblock.WithTableValidation(true),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.NotNil(t, client.ttlUpdateInput)
+	require.Equal(t, "expires_at", *client.ttlUpdateInput.TimeToLiveSpecification.AttributeName)
+}
@@ -0,0 +1,276 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Election elects one leader among the replicas campaigning on the same lock item.
+// The leader is the holder of the lock, its identity is the owner of the lock, see WithOwnerName,
+// and its term is the fencing token, which increases with every new leadership.
+// An Election is safe for concurrent use.
+type Election struct {
+	locker *DynamoDBLocker
+
+	mu  sync.Mutex
+	run *campaignRun
+}
+
+// campaignRun is a campaign from Campaign until Resign. Its fields are guarded by the mutex of the Election.
+type campaignRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// term is the current leadership, or the next one while this replica is not the leader.
+	term *leadership
+	// lease is the lease held by the campaign, it is released by Resign after done is closed.
+	lease *Lease
+}
+
+// leadership is a term of this replica. lease and next are set before ready is closed,
+// so that a waiter can follow the terms without missing a lost leadership.
+type leadership struct {
+	ready chan struct{}
+	lease *Lease
+	next  *leadership
+}
+
+func newLeadership() *leadership {
+	return &leadership{
+		ready: make(chan struct{}),
+	}
+}
+
+// Leader is the leader of an election.
+type Leader struct {
+	// Identity is the owner of the leader, its owner name with the hostname and PID. It is empty if there is no leader.
+	Identity string
+	Owner    Owner
+	// Term is the fencing token of the leadership.
+	Term int64
+}
+
+// NewElection returns *Election on the lock item of the url, like New.
+func NewElection(urlStr string, optFns ...func(*Options)) (*Election, error) {
+	locker, err := New(urlStr, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return newElection(locker), nil
+}
+
+func newElection(locker *DynamoDBLocker) *Election {
+	return &Election{
+		locker: locker,
+	}
+}
+
+// Identity returns the identity of this replica, which is the Identity of Leader while this replica is the leader.
+func (e *Election) Identity() string {
+	return e.locker.owner.String()
+}
+
+// Campaign blocks until this replica becomes the leader.
+// The campaign goes on in the background: after the leadership is lost, this replica campaigns again until Resign is called,
+// and the next Campaign blocks until it becomes the leader again.
+// If ctx is done before this replica becomes the leader, the campaign is stopped and Campaign returns the error of ctx.
+// If the election is resigned meanwhile, Campaign returns ErrNotGranted.
+func (e *Election) Campaign(ctx context.Context) error {
+	e.mu.Lock()
+	if e.run == nil {
+		campaignCtx, cancel := context.WithCancel(e.locker.defaultCtx)
+		e.run = &campaignRun{
+			cancel: cancel,
+			done:   make(chan struct{}),
+			term:   newLeadership(),
+		}
+		go e.campaign(campaignCtx, e.run)
+	}
+	run := e.run
+	term := run.term
+	e.mu.Unlock()
+	for {
+		select {
+		case <-term.ready:
+			if !isLost(term.lease) {
+				return nil
+			}
+			term = term.next
+		case <-run.done:
+			return ErrNotGranted
+		case <-ctx.Done():
+			e.mu.Lock()
+			stop := e.run == run && !run.leading()
+			if stop {
+				e.run = nil
+			}
+			e.mu.Unlock()
+			if stop {
+				if err := e.stop(context.Background(), run); err != nil {
+					e.locker.logger.Printf("[warn][setddblock] stop campaign failed: %s", err)
+				}
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func isLost(lease *Lease) bool {
+	select {
+	case <-lease.Lost():
+		return true
+	default:
+		return false
+	}
+}
+
+// leading reports whether the campaign holds the leadership, it must be called with the mutex of the Election.
+func (run *campaignRun) leading() bool {
+	return run.lease != nil && !isLost(run.lease)
+}
+
+// campaign acquires the lock and holds it until ctx is done, and acquires it again whenever it is lost.
+func (e *Election) campaign(ctx context.Context, run *campaignRun) {
+	defer close(run.done)
+	l := e.locker
+	e.mu.Lock()
+	term := run.term
+	e.mu.Unlock()
+	for {
+		lease, err := l.acquire(ctx, ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, ErrNotGranted) {
+				l.logger.Printf("[warn][setddblock] campaign for item_id=%s, table_name=%s failed: %s", l.itemID, l.tableName, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.leaseDuration):
+			}
+			continue
+		}
+		l.logger.Printf("[debug][setddblock] became leader of item_id=%s, table_name=%s, term=%d", l.itemID, l.tableName, lease.FencingToken())
+		e.mu.Lock()
+		run.lease = lease
+		term.lease = lease
+		term.next = newLeadership()
+		close(term.ready)
+		e.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-lease.Lost():
+		}
+		l.logger.Printf("[warn][setddblock] leadership of item_id=%s, table_name=%s, term=%d lost, campaign again: %s", l.itemID, l.tableName, lease.FencingToken(), lease.Err())
+		if err := lease.Release(context.Background()); err != nil {
+			l.logger.Printf("[warn][setddblock] %s", err)
+		}
+		e.mu.Lock()
+		run.lease = nil
+		run.term = term.next
+		term = term.next
+		e.mu.Unlock()
+	}
+}
+
+// Resign gives up the leadership and stops the campaign. It is a no-op if this replica is not campaigning.
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	run := e.run
+	e.run = nil
+	e.mu.Unlock()
+	if run == nil {
+		return nil
+	}
+	return e.stop(ctx, run)
+}
+
+// stop stops the campaign and releases its lease.
+func (e *Election) stop(ctx context.Context, run *campaignRun) error {
+	run.cancel()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-run.done:
+	}
+	e.mu.Lock()
+	lease := run.lease
+	e.mu.Unlock()
+	if lease != nil {
+		return lease.Release(ctx)
+	}
+	return nil
+}
+
+// IsLeader reports whether this replica is the leader.
+func (e *Election) IsLeader() bool {
+	return e.Lease() != nil
+}
+
+// Lease returns the lease of the leadership, or nil if this replica is not the leader.
+// Stop the work of the leader when the channel returned by Lost of the lease is closed.
+func (e *Election) Lease() *Lease {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.run == nil || !e.run.leading() {
+		return nil
+	}
+	return e.run.lease
+}
+
+// Leader returns the current leader. Leader has an empty Identity if there is no leader.
+func (e *Election) Leader(ctx context.Context) (Leader, error) {
+	details, err := e.locker.GetLockDetails(ctx)
+	if errors.Is(err, ErrNotLocked) {
+		return Leader{}, nil
+	}
+	if err != nil {
+		return Leader{}, err
+	}
+	if details.Expired() {
+		// the leader has stopped sending heartbeats.
+		return Leader{}, nil
+	}
+	return Leader{
+		Identity: details.Owner.String(),
+		Owner:    details.Owner,
+		Term:     details.FencingToken,
+	}, nil
+}
+
+// Observe returns a channel that receives the current leader, and then the leader whenever its identity or term changes.
+// The leader is read every watch interval, see WithWatchInterval, and a Leader with an empty Identity means there is no leader.
+// The channel is closed when ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan Leader {
+	ch := make(chan Leader)
+	go func() {
+		defer close(ch)
+		var last *Leader
+		for {
+			leader, err := e.Leader(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				e.locker.logger.Printf("[warn][setddblock] observe leader failed: %s", err)
+			} else if last == nil || last.Identity != leader.Identity || last.Term != leader.Term {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- leader:
+				}
+				last = &leader
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(e.locker.watchInterval):
+			}
+		}
+	}()
+	return ch
+}
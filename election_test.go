@@ -0,0 +1,120 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestElection(t *testing.T, backend setddblock.Backend, name string) *setddblock.Election {
+	t.Helper()
+	e, err := setddblock.NewElection(
+		"ddb://test/leader",
+		setddblock.WithBackend(backend),
+		setddblock.WithOwnerName(name),
+		setddblock.WithLeaseDuration(200*time.Millisecond),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, e.Resign(context.Background()))
+	})
+	return e
+}
+
+func TestElection(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, backend.CreateLockTable(ctx, "test"))
+	replica1 := newTestElection(t, backend, "replica1")
+	replica2 := newTestElection(t, backend, "replica2")
+
+	observed := replica2.Observe(ctx)
+	require.Equal(t, setddblock.Leader{}, <-observed, "no leader yet")
+
+	require.NoError(t, replica1.Campaign(ctx))
+	require.True(t, replica1.IsLeader())
+	leader := <-observed
+	require.Equal(t, replica1.Identity(), leader.Identity)
+	require.Equal(t, "replica1", leader.Owner.Name)
+	require.EqualValues(t, 1, leader.Term)
+
+	campaigned := make(chan error, 1)
+	go func() {
+		campaigned <- replica2.Campaign(ctx)
+	}()
+	select {
+	case err := <-campaigned:
+		t.Fatalf("replica2 became leader while replica1 is the leader: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	require.False(t, replica2.IsLeader())
+
+	require.NoError(t, replica1.Resign(ctx))
+	require.False(t, replica1.IsLeader())
+	require.NoError(t, <-campaigned)
+	require.True(t, replica2.IsLeader())
+	for leader.Identity != replica2.Identity() {
+		leader = <-observed
+	}
+	require.EqualValues(t, 2, leader.Term)
+	require.Equal(t, leader.Term, replica2.Lease().FencingToken())
+}
+
+func TestElectionCampaignAgain(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	replica := newTestElection(t, backend, "replica")
+	require.NoError(t, replica.Campaign(ctx))
+	lease := replica.Lease()
+
+	// someone else takes the lock item over, and releases it later.
+	prevRevision := lease.Revision()
+	_, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "leader",
+		Revision:      "other-holder",
+		PrevRevision:  &prevRevision,
+		LeaseDuration: time.Second,
+	})
+	require.NoError(t, err)
+	select {
+	case <-lease.Lost():
+	case <-ctx.Done():
+		t.Fatal("leadership lost was not notified")
+	}
+	otherRevision := "other-holder"
+	require.NoError(t, backend.ReleaseLock(ctx, &setddblock.LockInput{
+		TableName:    "test",
+		ItemID:       "leader",
+		PrevRevision: &otherRevision,
+	}))
+
+	require.NoError(t, replica.Campaign(ctx), "the replica campaigns again after the leadership is lost")
+	require.True(t, replica.IsLeader())
+	require.EqualValues(t, 3, replica.Lease().FencingToken())
+}
+
+func TestElectionCampaignCanceled(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	replica1 := newTestElection(t, backend, "replica1")
+	replica2 := newTestElection(t, backend, "replica2")
+	require.NoError(t, replica1.Campaign(ctx))
+
+	campaignCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, replica2.Campaign(campaignCtx), context.DeadlineExceeded)
+
+	require.NoError(t, replica1.Resign(ctx))
+	time.Sleep(500 * time.Millisecond)
+	require.False(t, replica2.IsLeader(), "the canceled campaign is stopped")
+	leader, err := replica2.Leader(ctx)
+	require.NoError(t, err)
+	require.Equal(t, setddblock.Leader{}, leader)
+}
@@ -0,0 +1,82 @@
+package setddblock
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// ErrNotGranted is returned when the lock is held by someone else and was not granted,
+// e.g. by Lock with WithNoPanic and WithDelay(false), or by LockContext.
+var ErrNotGranted = errors.New("lock not granted")
+
+// ErrAlreadyLocked is returned by LockWithErr when the locker already holds the lock.
+var ErrAlreadyLocked = errors.New("lock already granted")
+
+// ErrNotLocked is returned by UnlockWithErr when the locker does not hold the lock,
+// by SetPayload when the lock was released while the payload was being written, and by GetLockDetails when no one holds the lock.
+var ErrNotLocked = errors.New("lock not held")
+
+// ErrLockLost is returned when a held lock can no longer be proven to be owned,
+// because someone else has taken the lock item or the lease has run out.
+// Backends return an error wrapping ErrLockLost from SendHeartbeat when the stored revision no longer matches PrevRevision.
+var ErrLockLost = errors.New("lock lost")
+
+// ErrTableNotFound is returned when the lock table does not exist and it is not created, see WithAutoCreateTable.
+var ErrTableNotFound = errors.New("lock table not found")
+
+// ErrTableNotActive is returned when a created lock table does not become active within TableOptions.ActiveTimeout.
+var ErrTableNotActive = errors.New("lock table not active")
+
+// ErrFencingTokenMismatch is returned by ForceRelease when the lock is held by another lease than the one of the expected fencing token,
+// e.g. the lock has been released and acquired again since the fencing token was read.
+var ErrFencingTokenMismatch = errors.New("lock fencing token mismatch")
+
+// HeartbeatError is returned when a heartbeat fails to extend the lease of a held lock.
+// It wraps ErrLockLost if the lock has been lost, otherwise the error of the storage.
+type HeartbeatError struct {
+	TableName string
+	ItemID    string
+	Err       error
+}
+
+func (e *HeartbeatError) Error() string {
+	return fmt.Sprintf("heartbeat failed for item_id=%s, table_name=%s: %s", e.ItemID, e.TableName, e.Err)
+}
+
+func (e *HeartbeatError) Unwrap() error {
+	return e.Err
+}
+
+// LockBrokenError is the cause of a lost lock that was released by ForceRelease, and wraps ErrLockLost.
+// The evicted holder finds it in the error of its next heartbeat, see Lease.Err.
+type LockBrokenError struct {
+	BrokenBy Owner
+	BrokenAt time.Time
+	Reason   string
+}
+
+func (e *LockBrokenError) Error() string {
+	return fmt.Sprintf("%s: broken by %s at %s: %s", ErrLockLost, e.BrokenBy, e.BrokenAt.Format(time.RFC3339), e.Reason)
+}
+
+func (e *LockBrokenError) Unwrap() error {
+	return ErrLockLost
+}
+
+// lockLost returns the error of a failed condition on the revision of a held lock, which wraps ErrLockLost.
+func lockLost(err error) error {
+	return fmt.Errorf("%w: %s", ErrLockLost, err)
+}
+
+// isAPIError reports whether err is an error of the AWS API with the error code.
+func isAPIError(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+func isConditionalCheckFailed(err error) bool {
+	return isAPIError(err, "ConditionalCheckFailedException")
+}
@@ -0,0 +1,74 @@
+package setddblock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockerErrors(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	require.ErrorIs(t, locker.UnlockWithErr(ctx), setddblock.ErrNotLocked)
+
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	_, err = locker.LockWithErr(ctx)
+	require.ErrorIs(t, err, setddblock.ErrAlreadyLocked)
+
+	other, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend), setddblock.WithDelay(false))
+	require.NoError(t, err)
+	_, err = other.LockContext(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotGranted)
+
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.ErrorIs(t, locker.UnlockWithErr(ctx), setddblock.ErrNotLocked)
+}
+
+// takenOverStubDynamoDB fails every heartbeat with a generic API error, as if someone else had taken the item.
+type takenOverStubDynamoDB struct {
+	stubDynamoDB
+}
+
+func (c *takenOverStubDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if aws.ToString(params.ConditionExpression) == "attribute_not_exists(#ID) OR #Revision=:PrevRevision" {
+		c.record("UpdateItem " + aws.ToString(params.ConditionExpression))
+		return nil, &smithy.GenericAPIError{Code: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+	}
+	return c.stubDynamoDB.UpdateItem(ctx, params, optFns...)
+}
+
+func TestHeartbeatError(t *testing.T) {
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(&takenOverStubDynamoDB{}),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+		setddblock.WithNoPanic(),
+	)
+	require.NoError(t, err)
+	granted, err := locker.LockWithErr(context.Background())
+	require.NoError(t, err)
+	require.True(t, granted)
+	select {
+	case <-locker.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock lost was not notified")
+	}
+	var heartbeatErr *setddblock.HeartbeatError
+	require.True(t, errors.As(locker.LastErr(), &heartbeatErr))
+	require.Equal(t, "test", heartbeatErr.TableName)
+	require.Equal(t, "item1", heartbeatErr.ItemID)
+	require.ErrorIs(t, heartbeatErr, setddblock.ErrLockLost)
+	require.NoError(t, locker.UnlockWithErr(context.Background()))
+}
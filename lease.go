@@ -0,0 +1,289 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lease is a granted lock, handed out by Acquire of the locker.
+// It is the proof of ownership that can be passed around explicitly, and it is kept alive by heartbeats in the background
+// until it is released or lost. The locker hands out the next lease after this one is released.
+// A Lease is safe for concurrent use.
+type Lease struct {
+	locker       *DynamoDBLocker
+	acquiredAt   time.Time
+	fencingToken int64
+
+	mu     sync.Mutex
+	output *LockOutput
+	err    error
+
+	// input is owned by the heartbeat loop until done is closed.
+	input    *LockInput
+	requests chan heartbeatRequest
+	released chan struct{}
+	lost     chan struct{}
+	done     chan struct{}
+	// isReleased is guarded by the mutex of the locker.
+	isReleased bool
+	// stopped reports that the heartbeat loop has already released or lost the lock item, it is read after done is closed.
+	stopped bool
+}
+
+type heartbeatRequest struct {
+	payload    []byte
+	setPayload bool
+	result     chan error
+}
+
+func newLease(l *DynamoDBLocker, input *LockInput, output *LockOutput) *Lease {
+	return &Lease{
+		locker:       l,
+		acquiredAt:   time.Now(),
+		fencingToken: output.FencingToken,
+		output:       output,
+		input:        input,
+		requests:     make(chan heartbeatRequest),
+		released:     make(chan struct{}),
+		lost:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// TableName returns the table name of the lock.
+func (ls *Lease) TableName() string {
+	return ls.locker.tableName
+}
+
+// ItemID returns the item ID of the lock.
+// For a lease of DynamoDBMultiLocker, it is the item IDs joined by commas, use ItemIDs instead.
+func (ls *Lease) ItemID() string {
+	return ls.locker.itemID
+}
+
+// ItemIDs returns the item IDs of the lock, which are all of the items for a lease of DynamoDBMultiLocker.
+func (ls *Lease) ItemIDs() []string {
+	if ops, ok := ls.locker.ops.(*multiLeaseOps); ok {
+		return append([]string(nil), ops.itemIDs...)
+	}
+	return []string{ls.locker.itemID}
+}
+
+// Revision returns the current revision of the lease, which is renewed by every heartbeat.
+func (ls *Lease) Revision() string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.output.Revision
+}
+
+// FencingToken returns the fencing token of the acquisition, see DynamoDBLocker.FencingToken.
+func (ls *Lease) FencingToken() int64 {
+	return ls.fencingToken
+}
+
+// AcquiredAt returns the local time when the lease was granted.
+func (ls *Lease) AcquiredAt() time.Time {
+	return ls.acquiredAt
+}
+
+// Expiry returns the time until which the lease is proven by the last successful heartbeat.
+func (ls *Lease) Expiry() time.Time {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.output.NextHeartbeatLimit
+}
+
+// Lost returns a channel that is closed when the lease is lost.
+// The lease is lost when a heartbeat finds that someone else has taken the item, or when the lease runs out before a heartbeat succeeds.
+func (ls *Lease) Lost() <-chan struct{} {
+	return ls.lost
+}
+
+// Err returns the error that made the lease lost, which wraps ErrLockLost. It is nil while the lease is not lost.
+func (ls *Lease) Err() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.err
+}
+
+// Refresh sends a heartbeat immediately, and returns its error.
+// It returns ErrLockLost if the lease has been lost, and ErrNotLocked if it has been released.
+func (ls *Lease) Refresh(ctx context.Context) error {
+	return ls.heartbeat(ctx, heartbeatRequest{})
+}
+
+// Release releases the lock item and stops the heartbeats.
+// Releasing a lost lease only stops the heartbeats, and releasing a lease twice returns ErrNotLocked.
+func (ls *Lease) Release(ctx context.Context) error {
+	ls.locker.mu.Lock()
+	defer ls.locker.mu.Unlock()
+	return ls.release(ctx)
+}
+
+// release must be called with the mutex of the locker.
+func (ls *Lease) release(ctx context.Context) error {
+	l := ls.locker
+	if ls.isReleased {
+		return ErrNotLocked
+	}
+	ls.isReleased = true
+	if l.lease == ls {
+		l.lease = nil
+	}
+	close(ls.released)
+	<-ls.done
+	if ls.stopped {
+		return nil
+	}
+	ls.input.PrevRevision = &ls.output.Revision
+	if err := l.ops.ReleaseLock(ctx, ls.input); err != nil {
+		return fmt.Errorf("release lock failed: %w", err)
+	}
+	return nil
+}
+
+// heartbeat asks the heartbeat loop for an immediate heartbeat.
+func (ls *Lease) heartbeat(ctx context.Context, req heartbeatRequest) error {
+	req.result = make(chan error, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ls.lost:
+		return ErrLockLost
+	case <-ls.done:
+		select {
+		case <-ls.lost:
+			return ErrLockLost
+		default:
+			return ErrNotLocked
+		}
+	case ls.requests <- req:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-req.result:
+		return err
+	}
+}
+
+// keepAlive sends heartbeats until the lease is released or lost, or ctx is done.
+func (ls *Lease) keepAlive(ctx context.Context) {
+	l := ls.locker
+	input := ls.input
+	lockResult := ls.output
+	isLost := false
+	var lostErr error
+	defer func() {
+		if isLost {
+			l.logger.Printf("[warn][setddblock] lock lost for item_id=%s, table_name=%s: %s", l.itemID, l.tableName, lostErr)
+			ls.mu.Lock()
+			ls.err = lostErr
+			ls.mu.Unlock()
+			ls.stopped = true
+			close(ls.lost)
+		} else if ctx.Err() != nil {
+			input.PrevRevision = &lockResult.Revision
+			if err := l.ops.ReleaseLock(context.Background(), input); err != nil {
+				l.logger.Printf("[warn][setddblock] release lock failed: %s", err)
+			}
+			ls.stopped = true
+		}
+		l.logger.Printf("[debug][setddblock] finish background heartbeat for item_id=%s, table_name=%s at %s", l.itemID, l.tableName, time.Now().Format(time.RFC3339))
+		close(ls.done)
+	}()
+	// sendHeartbeat extends the lease, it returns true when the lock has been lost.
+	sendHeartbeat := func() (bool, error) {
+		l.logger.Println("[debug][setddblock] try send heartbeat")
+		input.PrevRevision = &lockResult.Revision
+		rev, err := l.generateRevision()
+		if err != nil {
+			l.setLastErr(err)
+			l.logger.Printf("[error][setddblock] generate revision failed in heartbeat: %s", err)
+			return false, err
+		}
+		input.Revision = rev
+		// the heartbeat can not prove ownership after the lease has run out.
+		heartbeatCtx, cancel := context.WithDeadline(ctx, lockResult.NextHeartbeatLimit)
+		ret, err := l.ops.SendHeartbeat(heartbeatCtx, input)
+		cancel()
+		if err != nil {
+			var heartbeatErr *HeartbeatError
+			if !errors.As(err, &heartbeatErr) {
+				err = &HeartbeatError{TableName: l.tableName, ItemID: l.itemID, Err: err}
+			}
+			l.logger.Printf("[error][setddblock] send heartbeat failed: %s", err)
+			if !errors.Is(err, ErrLockLost) && !time.Now().Before(lockResult.NextHeartbeatLimit) {
+				err = &HeartbeatError{
+					TableName: l.tableName,
+					ItemID:    l.itemID,
+					Err:       fmt.Errorf("%w: lease expired at %s: %s", ErrLockLost, lockResult.NextHeartbeatLimit.Format(time.RFC3339Nano), err),
+				}
+			}
+			l.setLastErr(err)
+			if errors.Is(err, ErrLockLost) {
+				lostErr = err
+				return true, err
+			}
+			return false, err
+		}
+		lockResult = ret
+		ls.mu.Lock()
+		ls.output = ret
+		ls.mu.Unlock()
+		return false, nil
+	}
+	nextHeartbeatTime := lockResult.NextHeartbeatLimit.Add(-time.Duration(float64(lockResult.LeaseDuration) * 0.2))
+	for {
+		sleepTime := time.Until(nextHeartbeatTime)
+		l.logger.Printf("[debug][setddblock] wait for next heartbeat time for item_id=%s, table_name=%s until %s (%s) at %s", l.itemID, l.tableName, nextHeartbeatTime, sleepTime, time.Now().Format(time.RFC3339))
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-ls.released:
+			return
+		case req := <-ls.requests:
+			// a new payload is written by an immediate heartbeat, and kept by the following ones.
+			prevPayload := input.Payload
+			if req.setPayload {
+				input.Payload = req.payload
+				input.ClearPayload = req.payload == nil
+			}
+			var gone bool
+			gone, err = sendHeartbeat()
+			input.ClearPayload = false
+			if err != nil {
+				input.Payload = prevPayload
+			}
+			req.result <- err
+			if gone {
+				isLost = true
+				return
+			}
+		case <-time.After(sleepTime):
+			var gone bool
+			gone, err = sendHeartbeat()
+			if gone {
+				isLost = true
+				return
+			}
+		}
+		nextHeartbeatTime = lockResult.NextHeartbeatLimit.Add(-time.Duration(float64(lockResult.LeaseDuration) * 0.2))
+		if err != nil {
+			// the next heartbeat time has passed, so a failed heartbeat is retried after a short delay until the lease runs out.
+			nextHeartbeatTime = time.Now().Add(heartbeatRetryDelay(lockResult.LeaseDuration))
+			if nextHeartbeatTime.After(lockResult.NextHeartbeatLimit) {
+				nextHeartbeatTime = lockResult.NextHeartbeatLimit
+			}
+		}
+	}
+}
+
+// heartbeatRetryDelay returns the delay before retrying a failed heartbeat, which is 5% of the lease duration.
+func heartbeatRetryDelay(leaseDuration time.Duration) time.Duration {
+	return time.Duration(float64(leaseDuration) * 0.05)
+}
@@ -0,0 +1,91 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(time.Second),
+	)
+	require.NoError(t, err)
+	require.Nil(t, locker.Lease())
+
+	lease, err := locker.Acquire(ctx)
+	require.NoError(t, err)
+	require.Same(t, lease, locker.Lease())
+	require.Equal(t, "item1", lease.ItemID())
+	require.EqualValues(t, 1, lease.FencingToken())
+	require.WithinDuration(t, time.Now(), lease.AcquiredAt(), time.Second)
+	details, err := locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Equal(t, details.Revision, lease.Revision())
+
+	other, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend), setddblock.WithDelay(false))
+	require.NoError(t, err)
+	_, err = other.Acquire(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotGranted)
+
+	revision, expiry := lease.Revision(), lease.Expiry()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, lease.Refresh(ctx))
+	require.NotEqual(t, revision, lease.Revision(), "a heartbeat renews the revision")
+	require.True(t, lease.Expiry().After(expiry), "a heartbeat extends the lease")
+
+	require.NoError(t, lease.Release(ctx))
+	require.Nil(t, locker.Lease())
+	require.ErrorIs(t, lease.Release(ctx), setddblock.ErrNotLocked)
+	require.ErrorIs(t, lease.Refresh(ctx), setddblock.ErrNotLocked)
+	require.NoError(t, lease.Err())
+
+	next, err := locker.Acquire(ctx)
+	require.NoError(t, err)
+	require.NotSame(t, lease, next)
+	require.EqualValues(t, 2, next.FencingToken(), "the locker hands out successive leases")
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.ErrorIs(t, next.Release(ctx), setddblock.ErrNotLocked)
+}
+
+func TestLeaseLost(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	lease, err := locker.Acquire(ctx)
+	require.NoError(t, err)
+
+	prevRevision := lease.Revision()
+	output, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "other-holder",
+		PrevRevision:  &prevRevision,
+		LeaseDuration: time.Second,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+
+	require.ErrorIs(t, lease.Refresh(ctx), setddblock.ErrLockLost)
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease lost was not notified")
+	}
+	require.ErrorIs(t, lease.Err(), setddblock.ErrLockLost)
+	require.ErrorIs(t, lease.Refresh(ctx), setddblock.ErrLockLost)
+	require.NoError(t, lease.Release(ctx))
+
+	details, err := locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "other-holder", details.Revision, "a lost lease does not release the item")
+}
@@ -0,0 +1,42 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+)
+
+// Lister is implemented by backends that can enumerate the locks of a lock table.
+type Lister interface {
+	Backend
+	// ListLocks returns a page of the held locks in the table, in no particular order.
+	// Released lock items, and items held only by readers or semaphore permits, are not listed.
+	ListLocks(ctx context.Context, tableName string, filter LockFilter) (*LockPage, error)
+}
+
+// LockFilter selects the locks returned by ListLocks.
+type LockFilter struct {
+	// ItemIDPrefix lists only the items whose ID starts with it.
+	ItemIDPrefix string
+	// Limit is the maximum number of items read for a page, 0 means no limit.
+	// A page can have fewer locks than Limit even if there are more pages, because items not matching the filter are read as well.
+	Limit int
+	// PageToken is the NextPageToken of the previous page, empty for the first page.
+	PageToken string
+}
+
+// LockPage is a page of the locks returned by ListLocks.
+type LockPage struct {
+	Locks []*LockDetails
+	// NextPageToken is the token of the next page, empty if this is the last page.
+	NextPageToken string
+}
+
+// ListLocks returns a page of the held locks in the table, see Lister.
+// Use Expired of LockDetails to find the locks whose holders have stopped sending heartbeats.
+func (c *Client) ListLocks(ctx context.Context, tableName string, filter LockFilter) (*LockPage, error) {
+	lister, ok := c.svc.(Lister)
+	if !ok {
+		return nil, errors.New("backend does not support listing locks")
+	}
+	return lister.ListLocks(ctx, tableName, filter)
+}
@@ -0,0 +1,139 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListLocks(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	client, err := setddblock.NewClient(
+		setddblock.WithBackend(backend),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(time.Minute),
+		setddblock.WithOwnerName("auditor-test"),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, itemID := range []string{"prod/a", "prod/b", "prod/c", "stg/a", "released"} {
+		locker, err := client.Locker("test", itemID)
+		require.NoError(t, err)
+		granted, err := locker.LockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted)
+		if itemID == "released" {
+			require.NoError(t, locker.UnlockWithErr(ctx))
+		} else {
+			defer locker.Unlock()
+		}
+	}
+	require.True(t, backend.Expire("test", "prod/b"))
+
+	page, err := client.ListLocks(ctx, "test", setddblock.LockFilter{})
+	require.NoError(t, err)
+	require.Empty(t, page.NextPageToken)
+	var itemIDs []string
+	for _, details := range page.Locks {
+		itemIDs = append(itemIDs, details.ItemID)
+		require.Equal(t, "auditor-test", details.Owner.Name)
+		require.Equal(t, details.ItemID == "prod/b", details.Expired(), details.ItemID)
+	}
+	require.Equal(t, []string{"prod/a", "prod/b", "prod/c", "stg/a"}, itemIDs, "released locks are not listed")
+
+	itemIDs = nil
+	filter := setddblock.LockFilter{ItemIDPrefix: "prod/", Limit: 2}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5)
+		page, err := client.ListLocks(ctx, "test", filter)
+		require.NoError(t, err)
+		for _, details := range page.Locks {
+			itemIDs = append(itemIDs, details.ItemID)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		filter.PageToken = page.NextPageToken
+	}
+	require.Equal(t, []string{"prod/a", "prod/b", "prod/c"}, itemIDs)
+}
+
+type scanStubDynamoDB struct {
+	stubDynamoDB
+	scans []*dynamodb.ScanInput
+}
+
+func (c *scanStubDynamoDB) Scan(_ context.Context, params *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	c.scans = append(c.scans, params)
+	output := &dynamodb.ScanOutput{
+		Items: []map[string]types.AttributeValue{
+			{
+				"PK":            &types.AttributeValueMemberS{Value: "LOCK#prod/a"},
+				"SK":            &types.AttributeValueMemberS{Value: "LOCK"},
+				"lock_revision": &types.AttributeValueMemberS{Value: "rev1"},
+				"Expires":       &types.AttributeValueMemberN{Value: "1000"},
+				"expires_at":    &types.AttributeValueMemberN{Value: "1"},
+				"FencingToken":  &types.AttributeValueMemberN{Value: "4"},
+				"OwnerName":     &types.AttributeValueMemberS{Value: "worker"},
+			},
+		},
+	}
+	if params.ExclusiveStartKey == nil {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "LOCK#prod/a"},
+			"SK": &types.AttributeValueMemberS{Value: "LOCK"},
+		}
+	}
+	return output, nil
+}
+
+func TestListLocksWithSchema(t *testing.T) {
+	stub := &scanStubDynamoDB{}
+	client, err := setddblock.NewClient(
+		setddblock.WithDynamoDBClient(stub),
+		setddblock.WithSchema(setddblock.Schema{
+			PartitionKey:       "PK",
+			PartitionKeyPrefix: "LOCK#",
+			SortKey:            "SK",
+			SortKeyValue:       "LOCK",
+			Revision:           "lock_revision",
+			TTL:                "expires_at",
+		}),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	page, err := client.ListLocks(ctx, "single_table", setddblock.LockFilter{ItemIDPrefix: "prod/", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Locks, 1)
+	details := page.Locks[0]
+	require.Equal(t, "prod/a", details.ItemID)
+	require.Equal(t, "rev1", details.Revision)
+	require.EqualValues(t, 4, details.FencingToken)
+	require.Equal(t, "worker", details.Owner.Name)
+	require.True(t, details.Expired())
+	require.NotEmpty(t, page.NextPageToken)
+
+	scan := stub.scans[0]
+	require.Equal(t, "attribute_exists(#Revision) AND begins_with(#ID, :Prefix) AND #SK = :SortKey", aws.ToString(scan.FilterExpression))
+	require.Equal(t, map[string]string{"#Revision": "lock_revision", "#ID": "PK", "#SK": "SK"}, scan.ExpressionAttributeNames)
+	require.Equal(t, &types.AttributeValueMemberS{Value: "LOCK#prod/"}, scan.ExpressionAttributeValues[":Prefix"])
+	require.EqualValues(t, 10, aws.ToInt32(scan.Limit))
+
+	page, err = client.ListLocks(ctx, "single_table", setddblock.LockFilter{PageToken: page.NextPageToken})
+	require.NoError(t, err)
+	require.Empty(t, page.NextPageToken)
+	require.Equal(t, map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "LOCK#prod/a"},
+		"SK": &types.AttributeValueMemberS{Value: "LOCK"},
+	}, stub.scans[1].ExclusiveStartKey, "the page token resumes the scan")
+
+	_, err = client.ListLocks(ctx, "single_table", setddblock.LockFilter{PageToken: "!"})
+	require.Error(t, err)
+}
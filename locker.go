@@ -2,7 +2,9 @@ package setddblock
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
 	"strings"
 	"sync"
@@ -13,19 +15,28 @@ import (
 
 // DynamoDBLocker implements the sync.Locker interface and provides a Lock mechanism using DynamoDB.
 type DynamoDBLocker struct {
-	mu            sync.Mutex
-	lastError     error
-	tableName     string
-	itemID        string
-	noPanic       bool
-	delay         bool
-	svc           *dynamoDBService
-	logger        Logger
-	leaseDuration time.Duration
-	unlockSignal  chan struct{}
-	locked        bool
-	wg            sync.WaitGroup
-	defaultCtx    context.Context
+	mu sync.Mutex
+	// errMu guards lastError, which the heartbeat loop sets while mu may be held waiting for the loop to finish.
+	errMu           sync.Mutex
+	lastError       error
+	tableName       string
+	itemID          string
+	noPanic         bool
+	delay           bool
+	svc             Backend
+	ops             leaseOps
+	logger          Logger
+	leaseDuration   time.Duration
+	skewTolerant    bool
+	watchInterval   time.Duration
+	owner           Owner
+	tables          *lockTables
+	autoCreateTable bool
+	payload         []byte
+	lease           *Lease
+	lost            chan struct{}
+	fencingToken    int64
+	defaultCtx      context.Context
 }
 
 // GetLockDetails retrieves the lock details for the current item.
@@ -38,6 +49,15 @@ func (l *DynamoDBLocker) ItemID() string {
 	return l.itemID
 }
 
+// FencingToken returns the fencing token of the last granted lock.
+// The token increases with every acquisition of the lock item,
+// so a downstream storage can reject writes carrying a token older than the newest one it has seen.
+func (l *DynamoDBLocker) FencingToken() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fencingToken
+}
+
 // TableName returns the table name of the lock.
 func (l *DynamoDBLocker) TableName() string {
 	return l.tableName
@@ -45,21 +65,36 @@ func (l *DynamoDBLocker) TableName() string {
 
 // New returns *DynamoDBLocker
 func New(urlStr string, optFns ...func(*Options)) (*DynamoDBLocker, error) {
-	u, err := url.Parse(urlStr)
+	scheme, tableName, itemID, err := parseLockURL(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := newOptionsWith(optFns)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := openBackend(scheme, opts)
 	if err != nil {
 		return nil, err
 	}
-	if u.Scheme != "ddb" && u.Scheme != "dynamodb" {
-		return nil, errors.New("scheme is required ddb or dynamodb")
+	return newLocker(tableName, itemID, svc, svc, opts), nil
+}
+
+func parseLockURL(urlStr string) (string, string, string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", "", "", err
 	}
 	if u.Host == "" {
-		return nil, errors.New("table_name is required: ddb://<table_name>/<item_id>")
+		return "", "", "", errors.New("table_name is required: ddb://<table_name>/<item_id>")
 	}
 	if u.Path == "" {
-		return nil, errors.New("table_name is required: ddb://<table_name>/<item_id>")
+		return "", "", "", errors.New("table_name is required: ddb://<table_name>/<item_id>")
 	}
-	tableName := u.Host
-	itemID := strings.TrimPrefix(u.Path, "/")
+	return u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func newOptionsWith(optFns []func(*Options)) (*Options, error) {
 	opts := newOptions()
 	for _, optFn := range optFns {
 		optFn(opts)
@@ -70,20 +105,60 @@ func New(urlStr string, optFns ...func(*Options)) (*DynamoDBLocker, error) {
 	if opts.LeaseDuration < 100*time.Millisecond {
 		return nil, errors.New("lease duration is so short, please set over 100 milli second")
 	}
-	svc, err := newDynamoDBService(opts)
-	if err != nil {
-		return nil, err
+	if opts.Table.ActiveTimeout <= 0 {
+		return nil, errors.New("table active timeout must be positive")
+	}
+	if opts.provisioned || opts.Table.ReadCapacityUnits != 0 || opts.Table.WriteCapacityUnits != 0 {
+		if opts.Table.ReadCapacityUnits <= 0 || opts.Table.WriteCapacityUnits <= 0 {
+			return nil, errors.New("read and write capacity units must be positive")
+		}
+	}
+	if opts.ReleasedItemRetention < 0 {
+		return nil, errors.New("released item retention must not be negative")
+	}
+	if opts.WatchInterval < 0 {
+		return nil, errors.New("watch interval must not be negative")
+	}
+	if opts.payloadErr != nil {
+		return nil, opts.payloadErr
+	}
+	return opts, nil
+}
+
+func newLocker(tableName, itemID string, svc Backend, ops leaseOps, opts *Options) *DynamoDBLocker {
+	tables := opts.tables
+	if tables == nil {
+		tables = newLockTables()
+	}
+	watchInterval := opts.WatchInterval
+	if watchInterval == 0 {
+		watchInterval = opts.LeaseDuration / 2
 	}
 	return &DynamoDBLocker{
-		logger:        opts.Logger,
-		noPanic:       opts.NoPanic,
-		delay:         opts.Delay,
-		tableName:     tableName,
-		itemID:        itemID,
-		svc:           svc,
-		leaseDuration: opts.LeaseDuration,
-		defaultCtx:    opts.ctx,
-	}, nil
+		logger:          opts.Logger,
+		noPanic:         opts.NoPanic,
+		delay:           opts.Delay,
+		tableName:       tableName,
+		itemID:          itemID,
+		svc:             svc,
+		ops:             ops,
+		leaseDuration:   opts.LeaseDuration,
+		skewTolerant:    opts.ClockSkewTolerant,
+		watchInterval:   watchInterval,
+		owner:           currentOwner(opts.OwnerName),
+		payload:         opts.Payload,
+		tables:          tables,
+		autoCreateTable: opts.AutoCreateTable,
+		defaultCtx:      opts.ctx,
+	}
+}
+
+// leaseOps is the set of lease operations that DynamoDBLocker runs its acquire and heartbeat loop on.
+// Backend satisfies it for exclusive locks, other lock kinds adapt their own Backend operations.
+type leaseOps interface {
+	AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error)
+	ReleaseLock(ctx context.Context, parms *LockInput) error
 }
 
 func (l *DynamoDBLocker) generateRevision() (string, error) {
@@ -99,116 +174,187 @@ func (l *DynamoDBLocker) generateRevision() (string, error) {
 func (l *DynamoDBLocker) LockWithErr(ctx context.Context) (bool, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.logger.Println("[debug][setddblock] start - LockWithErr")
-	if l.locked {
-		return true, errors.New("aleady lock granted")
+	lease, err := l.lockWithErr(ctx, ctx)
+	if errors.Is(err, ErrAlreadyLocked) {
+		return true, err
 	}
-	exists, err := l.svc.LockTableExists(ctx, l.tableName)
+	return lease != nil, err
+}
+
+// Acquire try get lock like LockWithErr, and returns the granted *Lease.
+// If the lock was not granted, Acquire returns ErrNotGranted.
+// The lock is held until the lease is released by Lease.Release or UnlockWithErr, and then Acquire can be called again.
+func (l *DynamoDBLocker) Acquire(ctx context.Context) (*Lease, error) {
+	return l.acquire(ctx, ctx)
+}
+
+// acquire is Acquire whose lease is kept alive until leaseCtx is done, regardless of ctx.
+func (l *DynamoDBLocker) acquire(ctx, leaseCtx context.Context) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease, err := l.lockWithErr(ctx, leaseCtx)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	l.logger.Printf("[debug][setddblock] lock table exists = %v", exists)
-	if !exists {
-		if err := l.svc.CreateLockTable(ctx, l.tableName); err != nil {
-			return false, err
+	if lease == nil {
+		return nil, ErrNotGranted
+	}
+	return lease, nil
+}
+
+// Lease returns the lease held by the locker, or nil if the lock is not held.
+func (l *DynamoDBLocker) Lease() *Lease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lease
+}
+
+// LockContext try get lock like LockWithErr, and returns a context bound to the lease.
+// The returned context is derived from ctx and is cancelled when the lock is lost or released by UnlockWithErr.
+// If the lock was not granted, LockContext returns an error.
+func (l *DynamoDBLocker) LockContext(ctx context.Context) (context.Context, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease, err := l.lockWithErr(ctx, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		return nil, ErrNotGranted
+	}
+	leaseCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-lease.lost:
+		case <-lease.released:
+		case <-leaseCtx.Done():
 		}
+	}()
+	return leaseCtx, nil
+}
+
+// lockWithErr returns the granted lease, or nil if the lock was not granted.
+// ctx bounds the acquisition, and leaseCtx bounds the heartbeats of the granted lease.
+func (l *DynamoDBLocker) lockWithErr(ctx, leaseCtx context.Context) (*Lease, error) {
+	l.logger.Println("[debug][setddblock] start - LockWithErr")
+	if l.lease != nil {
+		return nil, ErrAlreadyLocked
+	}
+	if err := l.tables.ensure(ctx, l.svc, l.tableName, l.autoCreateTable, l.logger); err != nil {
+		return nil, err
 	}
 	rev, err := l.generateRevision()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	l.logger.Println("[debug][setddblock] try - acquire lock")
-	input := &lockInput{
+	input := &LockInput{
 		TableName:     l.tableName,
 		ItemID:        l.itemID,
 		LeaseDuration: l.leaseDuration,
 		Revision:      rev,
+		Owner:         l.owner,
+		Payload:       l.payload,
+		IgnoreExpiry:  l.skewTolerant,
+		Wait:          l.delay,
 	}
-	lockResult, err := l.svc.AcquireLock(ctx, input)
+	lockResult, err := l.ops.AcquireLock(ctx, input)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if lockResult == nil {
 		// Lock is considered expired due to TTL
 		l.logger.Printf("[debug][setddblock] lock expired due to TTL for item_id=%s, table_name=%s, current_time=%s", l.itemID, l.tableName, time.Now().Format(time.RFC3339))
-		return false, nil
+		return nil, nil
 	}
 	if lockResult == nil {
 		l.logger.Printf("[debug][setddblock] acquire lock result is nil for table_name=%s, item_id=%s", l.tableName, l.itemID)
-		return false, nil
+		return nil, nil
 	}
 	if !lockResult.LockGranted && !l.delay {
-		return false, nil
+		return nil, nil
 	}
+	// observedAt is the time on the monotonic clock when the revision of the holder was first seen.
+	observedRevision, observedAt := lockResult.Revision, time.Now()
 	for !lockResult.LockGranted {
 		sleepTime := time.Until(lockResult.NextHeartbeatLimit)
+		if l.skewTolerant {
+			sleepTime = lockResult.LeaseDuration - time.Since(observedAt)
+		}
 		l.logger.Printf("[debug][setddblock] wait for next acquire lock until %s (%s)", lockResult.NextHeartbeatLimit, sleepTime)
 		select {
 		case <-ctx.Done():
-			return false, ctx.Err()
+			return nil, ctx.Err()
 		case <-time.After(sleepTime):
 		}
 		input.PrevRevision = &lockResult.Revision
 		input.Revision, err = l.generateRevision()
 		if err != nil {
-			return false, err
+			return nil, err
 		}
-		lockResult, err = l.svc.AcquireLock(ctx, input)
+		lockResult, err = l.ops.AcquireLock(ctx, input)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		l.logger.Printf("[debug][setddblock] now revision %s", lockResult.Revision)
+		if lockResult.Revision != observedRevision {
+			observedRevision, observedAt = lockResult.Revision, time.Now()
+		}
 	}
 	l.logger.Println("[debug][setddblock] success - lock granted")
-	l.locked = true
-	l.unlockSignal = make(chan struct{})
-	l.wg = sync.WaitGroup{}
-	l.wg.Add(1)
-	go func() {
-		defer func() {
-			if lockResult != nil {
-				input.PrevRevision = &lockResult.Revision
-				if err := l.svc.ReleaseLock(context.Background(), input); err != nil {
-					l.logger.Printf("[warn][setddblock] release lock failed: %s", err)
-				}
-			} else {
-				l.logger.Printf("[warn][setddblock] lock result is nil last error: %s", l.lastError)
-			}
-
-			l.logger.Printf("[debug][setddblock] finish background heartbeat for item_id=%s, table_name=%s at %s", l.itemID, l.tableName, time.Now().Format(time.RFC3339))
-			l.wg.Done()
-		}()
-		nextHeartbeatTime := lockResult.NextHeartbeatLimit.Add(-time.Duration(float64(lockResult.LeaseDuration) * 0.2))
-		for {
-			sleepTime := time.Until(nextHeartbeatTime)
-			l.logger.Printf("[debug][setddblock] wait for next heartbeat time for item_id=%s, table_name=%s until %s (%s) at %s", l.itemID, l.tableName, nextHeartbeatTime, sleepTime, time.Now().Format(time.RFC3339))
-			select {
-			case <-ctx.Done():
-				return
-			case <-l.unlockSignal:
-				return
-			case <-time.After(sleepTime):
-			}
-			l.logger.Println("[debug][setddblock] try send heartbeat")
-			input.PrevRevision = &lockResult.Revision
-			input.Revision, err = l.generateRevision()
-			if err != nil {
-				l.lastError = err
-				l.logger.Println("[error][setddblock] generate revision failed in heartbeat: %s", err)
-				continue
-			}
-			lockResult, err = l.svc.SendHeartbeat(ctx, input)
-			if err != nil {
-				l.lastError = err
-				l.logger.Println("[error][setddblock] send heartbeat failed: %s", err)
-				continue
-			}
-			nextHeartbeatTime = lockResult.NextHeartbeatLimit.Add(-time.Duration(float64(lockResult.LeaseDuration) * 0.2))
-		}
-	}()
+	lease := newLease(l, input, lockResult)
+	l.lease = lease
+	l.fencingToken = lease.fencingToken
+	l.lost = lease.lost
+	go lease.keepAlive(leaseCtx)
 	l.logger.Println("[debug][setddblock] end -LockWithErr")
-	return true, nil
+	return lease, nil
+}
+
+// SetPayload replaces the payload stored on the lock item.
+// While the lock is held, the payload is written immediately by a heartbeat, and SetPayload returns the error of that heartbeat.
+// Otherwise it is stored by the next acquisition. A nil payload removes the stored one.
+// Read leases of DynamoDBRWLocker and permits of DynamoDBSemaphore have no payload, and SetPayload of them returns an error.
+func (l *DynamoDBLocker) SetPayload(ctx context.Context, payload []byte) error {
+	if kind := leaseKindOf(l.ops); kind == LeaseKindRead || kind == LeaseKindPermit {
+		return fmt.Errorf("%s lease does not support payload", kind)
+	}
+	l.mu.Lock()
+	lease := l.lease
+	if lease == nil {
+		l.payload = payload
+		l.mu.Unlock()
+		return nil
+	}
+	l.mu.Unlock()
+	if err := lease.heartbeat(ctx, heartbeatRequest{payload: payload, setPayload: true}); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.payload = payload
+	return nil
+}
+
+// SetJSONPayload is SetPayload with the JSON encoding of v.
+func (l *DynamoDBLocker) SetJSONPayload(ctx context.Context, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	return l.SetPayload(ctx, payload)
+}
+
+// Lost returns a channel that is closed when the lock granted by LockWithErr is lost.
+// The lock is lost when a heartbeat finds that someone else has taken the item, or when the lease runs out before a heartbeat succeeds.
+// After the channel is closed, LastErr returns an error wrapping ErrLockLost.
+// Lost returns nil if the lock has never been granted.
+func (l *DynamoDBLocker) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
 }
 
 // Lock for implements sync.Locker
@@ -218,7 +364,7 @@ func (l *DynamoDBLocker) Lock() {
 		l.bailout(err)
 	}
 	if !lockGranted {
-		l.bailout(errors.New("lock was not granted"))
+		l.bailout(ErrNotGranted)
 	}
 }
 
@@ -227,12 +373,12 @@ func (l *DynamoDBLocker) UnlockWithErr(_ context.Context) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.logger.Println("[debug][setddblock] start - UnlockWithErr")
-	if !l.locked {
-		return errors.New("not lock granted")
+	if l.lease == nil {
+		return ErrNotLocked
+	}
+	if err := l.lease.release(context.Background()); err != nil {
+		l.logger.Printf("[warn][setddblock] %s", err)
 	}
-	close(l.unlockSignal)
-	l.locked = false
-	l.wg.Wait()
 	l.logger.Println("[debug][setddblock] end - UnlockWithErr")
 	return nil
 }
@@ -245,15 +391,19 @@ func (l *DynamoDBLocker) Unlock() {
 }
 
 func (l *DynamoDBLocker) LastErr() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.errMu.Lock()
+	defer l.errMu.Unlock()
 	return l.lastError
 }
 
 func (l *DynamoDBLocker) ClearLastErr() {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.lastError = nil
+	l.setLastErr(nil)
+}
+
+func (l *DynamoDBLocker) setLastErr(err error) {
+	l.errMu.Lock()
+	defer l.errMu.Unlock()
+	l.lastError = err
 }
 
 type bailoutErr struct {
@@ -261,9 +411,7 @@ type bailoutErr struct {
 }
 
 func (l *DynamoDBLocker) bailout(err error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.lastError = err
+	l.setLastErr(err)
 	if !l.noPanic {
 		panic(bailoutErr{err: err})
 	}
@@ -0,0 +1,75 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockContextUnlock(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	leaseCtx, err := locker.LockContext(ctx)
+	require.NoError(t, err)
+	require.NoError(t, leaseCtx.Err())
+
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	select {
+	case <-leaseCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease context was not cancelled by unlock")
+	}
+}
+
+func TestLockContextLost(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(time.Second),
+	)
+	require.NoError(t, err)
+	leaseCtx, err := locker.LockContext(ctx)
+	require.NoError(t, err)
+
+	details, err := locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	_, err = backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "other-holder",
+		PrevRevision:  &details.Revision,
+		LeaseDuration: time.Second,
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-leaseCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lease context was not cancelled by lock lost")
+	}
+	require.NoError(t, locker.UnlockWithErr(ctx))
+}
+
+func TestLockContextNotGranted(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker1, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	locker2, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend), setddblock.WithDelay(false))
+	require.NoError(t, err)
+	_, err = locker1.LockContext(ctx)
+	require.NoError(t, err)
+	defer locker1.Unlock()
+
+	leaseCtx, err := locker2.LockContext(ctx)
+	require.Error(t, err)
+	require.Nil(t, leaseCtx)
+}
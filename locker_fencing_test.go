@@ -0,0 +1,36 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFencingToken(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker1, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	locker2, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+
+	var lastToken int64
+	for i := 0; i < 3; i++ {
+		for _, locker := range []*setddblock.DynamoDBLocker{locker1, locker2} {
+			granted, err := locker.LockWithErr(ctx)
+			require.NoError(t, err)
+			require.True(t, granted)
+			require.Greater(t, locker.FencingToken(), lastToken, "fencing token increases with every acquisition")
+			lastToken = locker.FencingToken()
+
+			details, err := locker.GetLockDetails(ctx)
+			require.NoError(t, err)
+			require.Equal(t, lastToken, details.FencingToken)
+			require.NoError(t, locker.UnlockWithErr(ctx))
+		}
+	}
+	require.EqualValues(t, 6, lastToken)
+}
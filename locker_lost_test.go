@@ -0,0 +1,176 @@
+package setddblock_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLostByTakeover(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(time.Second),
+	)
+	require.NoError(t, err)
+	require.Nil(t, locker.Lost())
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	details, err := locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	output, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "other-holder",
+		PrevRevision:  &details.Revision,
+		LeaseDuration: time.Second,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+
+	select {
+	case <-locker.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock lost was not notified")
+	}
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.ErrorIs(t, locker.LastErr(), setddblock.ErrLockLost)
+
+	details, err = locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "other-holder", details.Revision, "lost lock is not released")
+}
+
+type unavailableHeartbeatBackend struct {
+	*setddblocktest.Backend
+}
+
+func (b unavailableHeartbeatBackend) SendHeartbeat(_ context.Context, _ *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestLostByLeaseExpired(t *testing.T) {
+	backend := unavailableHeartbeatBackend{Backend: setddblocktest.NewBackend()}
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(200*time.Millisecond),
+	)
+	require.NoError(t, err)
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	select {
+	case <-locker.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock lost was not notified")
+	}
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	require.ErrorIs(t, locker.LastErr(), setddblock.ErrLockLost)
+}
+
+// countingUnavailableHeartbeatBackend fails every heartbeat, and counts them.
+type countingUnavailableHeartbeatBackend struct {
+	*setddblocktest.Backend
+	heartbeats int32
+}
+
+func (b *countingUnavailableHeartbeatBackend) SendHeartbeat(_ context.Context, _ *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	atomic.AddInt32(&b.heartbeats, 1)
+	return nil, errors.New("service unavailable")
+}
+
+func TestHeartbeatRetryDelay(t *testing.T) {
+	backend := &countingUnavailableHeartbeatBackend{Backend: setddblocktest.NewBackend()}
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(time.Second),
+	)
+	require.NoError(t, err)
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	timeout := time.After(2 * time.Second)
+	for lost := false; !lost; {
+		select {
+		case <-locker.Lost():
+			lost = true
+		case <-timeout:
+			t.Fatal("lock lost was not notified")
+		default:
+			// LastErr is read while the heartbeat loop sets it.
+			_ = locker.LastErr()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	require.ErrorIs(t, locker.LastErr(), setddblock.ErrLockLost)
+	heartbeats := atomic.LoadInt32(&backend.heartbeats)
+	require.GreaterOrEqual(t, heartbeats, int32(2), "a failed heartbeat is retried")
+	require.LessOrEqual(t, heartbeats, int32(6), "a failed heartbeat is retried after a delay of 5% of the lease")
+	require.NoError(t, locker.UnlockWithErr(ctx))
+}
+
+func TestExpiredLockTakeover(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	holder, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(time.Second),
+	)
+	require.NoError(t, err)
+	granted, err := holder.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.True(t, backend.Expire("test", "item1"))
+
+	var wg sync.WaitGroup
+	var winners int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			waiter, err := setddblock.New(
+				"ddb://test/item1",
+				setddblock.WithBackend(backend),
+				setddblock.WithDelay(false),
+				setddblock.WithLeaseDuration(time.Second),
+			)
+			require.NoError(t, err)
+			granted, err := waiter.LockWithErr(ctx)
+			require.NoError(t, err)
+			if granted {
+				atomic.AddInt32(&winners, 1)
+				details, err := waiter.GetLockDetails(ctx)
+				require.NoError(t, err)
+				require.EqualValues(t, waiter.FencingToken(), details.FencingToken, "the winner holds its own revision")
+				t.Cleanup(waiter.Unlock)
+			}
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, winners, "exactly one waiter takes over the expired lock")
+
+	select {
+	case <-holder.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock lost was not notified")
+	}
+	require.NoError(t, holder.UnlockWithErr(ctx))
+}
@@ -0,0 +1,41 @@
+package setddblock_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnerMetadata(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithOwnerName("nightly-batch"),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	before := time.Now()
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer locker.Unlock()
+
+	time.Sleep(200 * time.Millisecond) // heartbeats keep the owner
+	details, err := locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	require.Equal(t, "nightly-batch", details.Owner.Name)
+	require.Equal(t, hostname, details.Owner.Hostname)
+	require.Equal(t, os.Getpid(), details.Owner.PID)
+	require.False(t, details.Owner.ProcessStartTime.IsZero())
+	require.WithinDuration(t, before, details.AcquiredAt, 100*time.Millisecond)
+	require.Contains(t, details.Owner.String(), "nightly-batch")
+}
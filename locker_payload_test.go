@@ -0,0 +1,113 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+type jobPayload struct {
+	JobID    string `json:"job_id"`
+	Progress int    `json:"progress"`
+}
+
+func TestPayload(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithJSONPayload(jobPayload{JobID: "job-1"}),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	observer, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	time.Sleep(200 * time.Millisecond) // heartbeats keep the payload
+	details, err := observer.GetLockDetails(ctx)
+	require.NoError(t, err)
+	var payload jobPayload
+	require.NoError(t, details.UnmarshalPayload(&payload))
+	require.Equal(t, jobPayload{JobID: "job-1"}, payload)
+
+	require.NoError(t, locker.SetJSONPayload(ctx, jobPayload{JobID: "job-1", Progress: 50}))
+	details, err = observer.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.NoError(t, details.UnmarshalPayload(&payload))
+	require.Equal(t, jobPayload{JobID: "job-1", Progress: 50}, payload)
+
+	time.Sleep(200 * time.Millisecond)
+	details, err = observer.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"job_id":"job-1","progress":50}`, string(details.Payload))
+
+	require.NoError(t, locker.UnlockWithErr(ctx))
+	granted, err = observer.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer observer.Unlock()
+	details, err = observer.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Nil(t, details.Payload, "the payload of the previous holder is removed")
+}
+
+func TestPayloadInvalidJSON(t *testing.T) {
+	_, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(setddblocktest.NewBackend()),
+		setddblock.WithJSONPayload(make(chan int)),
+	)
+	require.Error(t, err)
+}
+
+func TestSetPayloadNil(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithPayload([]byte("job-1")),
+	)
+	require.NoError(t, err)
+	granted, err := locker.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer locker.Unlock()
+
+	require.NoError(t, locker.SetPayload(ctx, nil))
+	details, err := locker.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Nil(t, details.Payload, "SetPayload(nil) removes the payload")
+}
+
+func TestPayloadHolderLeases(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	_, err := setddblock.NewSemaphore("ddb://test/sem", 2, setddblock.WithBackend(backend), setddblock.WithPayload([]byte("job-1")))
+	require.Error(t, err)
+
+	sem, err := setddblock.NewSemaphore("ddb://test/sem", 2, setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	granted, err := sem.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer sem.Unlock()
+	require.Error(t, sem.SetPayload(ctx, []byte("job-1")))
+
+	rw, err := setddblock.NewRW("ddb://test/rw", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	granted, err = rw.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer rw.RUnlock()
+	require.Error(t, rw.RLocker().SetPayload(ctx, []byte("job-1")))
+}
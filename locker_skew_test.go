@@ -0,0 +1,80 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockSkewToleranceKeepsLiveHolder(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	holder, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(200*time.Millisecond),
+	)
+	require.NoError(t, err)
+	granted, err := holder.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	defer holder.Unlock()
+	// the clock of the holder is behind, so the expiry written by it is already in the past.
+	require.True(t, backend.Expire("test", "item1"))
+
+	waiter, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(200*time.Millisecond),
+		setddblock.WithClockSkewTolerance(true),
+	)
+	require.NoError(t, err)
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	granted, err = waiter.LockWithErr(waitCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, granted, "the lock of a holder sending heartbeats is not taken over")
+}
+
+func TestClockSkewToleranceTakesOverObservedLease(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	require.NoError(t, backend.CreateLockTable(ctx, "test"))
+	// a crashed holder which sends no heartbeats.
+	output, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "crashed-holder",
+		LeaseDuration: 300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+
+	waiter, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(200*time.Millisecond),
+		setddblock.WithClockSkewTolerance(true),
+	)
+	require.NoError(t, err)
+	start := time.Now()
+	granted, err := waiter.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond, "the lease is observed for its full duration")
+	require.NoError(t, waiter.UnlockWithErr(ctx))
+}
+
+func TestClockSkewToleranceExclusiveOnly(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	_, err := setddblock.NewRW("ddb://test/rw", setddblock.WithBackend(backend), setddblock.WithClockSkewTolerance(true))
+	require.Error(t, err, "reader leases expire by their stored time")
+	_, err = setddblock.NewSemaphore("ddb://test/sem", 2, setddblock.WithBackend(backend), setddblock.WithClockSkewTolerance(true))
+	require.Error(t, err, "permit leases expire by their stored time")
+	_, err = setddblock.NewMulti([]string{"ddb://test/item1", "ddb://test/item2"}, setddblock.WithBackend(backend), setddblock.WithClockSkewTolerance(true))
+	require.NoError(t, err)
+}
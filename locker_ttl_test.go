@@ -5,38 +5,39 @@ package setddblock_test
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"strconv"
 	"testing"
 	"time"
-  "log"
-
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/fujiwara/logutils"
 	"github.com/mashiike/setddblock"
 	"github.com/stretchr/testify/require"
-  "github.com/fujiwara/logutils"
-
 )
 
 /*
-TestTTLExpirationLock aims to verify that a DynamoDB-based lock expires as expected based on its TTL.
+TestTTLExpirationLock aims to verify that a DynamoDB-based lock expires as expected based on its lease expiry.
 The test follows these steps:
-1. Acquire an initial lock with a defined TTL (5 seconds).
-   - This lock is created using `DynamoDBLocker` and is intentionally left "unreleased" by killing the process to simulate a process crash.
-2. Check the lock's `TTL` and `Revision` attributes directly in DynamoDB.
-   - We use the AWS SDK to confirm the lock's TTL and verify that DynamoDB has recorded it.
-3. Continuously attempt to acquire the same lock before the TTL expires.
-   - Each acquisition attempt should fail until the TTL expires, confirming the lock is held until DynamoDB releases it.
-4. Once the TTL expires, validate that the lock can now be reacquired.
-   - This confirms that the time it took to reacquire the lock matches or exceeds the expected TTL, showing that the lock was released due to TTL expiration.
+1. Acquire an initial lock with a defined lease duration.
+  - This lock is created using `DynamoDBLocker` and is intentionally left "unreleased" by killing the process to simulate a process crash.
+
+2. Check the lock's `Expires` and `Revision` attributes directly in DynamoDB.
+  - We use the AWS SDK to confirm the end of the lease in unix milliseconds that DynamoDB has recorded.
+
+3. Continuously attempt to acquire the same lock before the lease expires.
+  - Each acquisition attempt should fail until the lease expires, confirming the lock is held until then.
+
+4. Once the lease expires, validate that the lock can now be reacquired.
+  - This confirms that the lock is taken over no earlier than `Expires`, and soon after it.
 */
 
-func getItemDetails(client *dynamodb.Client, tableName, itemID string) (int64, string, error) {
+func getItemDetails(client *dynamodb.Client, tableName, itemID string) (time.Time, string, error) {
 	result, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
@@ -44,30 +45,30 @@ func getItemDetails(client *dynamodb.Client, tableName, itemID string) (int64, s
 		},
 	})
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to query DynamoDB: %w", err)
+		return time.Time{}, "", fmt.Errorf("failed to query DynamoDB: %w", err)
 	}
 
-	var ttl int64
-	if ttlAttr, ok := result.Item["ttl"].(*types.AttributeValueMemberN); ok {
-		ttl, err = strconv.ParseInt(ttlAttr.Value, 10, 64)
+	var expires time.Time
+	if expiresAttr, ok := result.Item["Expires"].(*types.AttributeValueMemberN); ok {
+		millis, err := strconv.ParseInt(expiresAttr.Value, 10, 64)
 		if err != nil {
-			return 0, "", fmt.Errorf("failed to parse TTL attribute: %w", err)
+			return time.Time{}, "", fmt.Errorf("failed to parse Expires attribute: %w", err)
 		}
+		expires = time.UnixMilli(millis)
 	} else {
-		return 0, "", fmt.Errorf("TTL attribute is missing or has an unexpected type")
+		return time.Time{}, "", fmt.Errorf("Expires attribute is missing or has an unexpected type")
 	}
 
 	revision := ""
 	if revisionAttr, ok := result.Item["Revision"].(*types.AttributeValueMemberS); ok {
 		revision = revisionAttr.Value
 	} else {
-		return 0, "", fmt.Errorf("Revision attribute is missing or has an unexpected type")
+		return time.Time{}, "", fmt.Errorf("Revision attribute is missing or has an unexpected type")
 	}
 
-	return ttl, revision, nil
+	return expires, revision, nil
 }
 
-
 func setupDynamoDBClient(t *testing.T) *dynamodb.Client {
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithEndpointResolverWithOptions(
 		aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
@@ -81,54 +82,31 @@ func setupDynamoDBClient(t *testing.T) *dynamodb.Client {
 	return dynamodb.NewFromConfig(cfg)
 }
 
+func tryAcquireLock(t *testing.T, logger *log.Logger) (bool, time.Time) {
+	locker, err := setddblock.New(
+		fmt.Sprintf("ddb://%s/%s", lockTableName, lockItemID),
+		setddblock.WithEndpoint(dynamoDBURL),
+		setddblock.WithLeaseDuration(5*time.Second),
+		setddblock.WithDelay(false),
+		setddblock.WithNoPanic(),
+		setddblock.WithLogger(logger),
+	)
+	require.NoError(t, err, "Failed to create locker for retry")
 
-
-// toggle --debug style logging for setddblock
-// var enableDebug = false
-var enableDebug = true
-
-
-
-func tryAcquireLock(t *testing.T, logger *log.Logger, retryCount int) (bool, time.Time) {
-    options := []func(*setddblock.Options){
-        setddblock.WithEndpoint(dynamoDBURL),
-        setddblock.WithLeaseDuration(5 * time.Second),
-        setddblock.WithDelay(false),
-        setddblock.WithNoPanic(),
-    }
-    if enableDebug {
-        options = append(options, setddblock.WithLogger(logger))
-    }
-    locker, err := setddblock.New(
-        fmt.Sprintf("ddb://%s/%s", lockTableName, lockItemID),
-        options...,
-    )
-    require.NoError(t, err, "Failed to create locker for retry")
-
-    locker.Lock()
-
-    // I'm on the fence here - use 1 second precision or actual.
-    // the original ttl isn't a subsecond timestamp, so when we calculate it, it's not "real"
-    if locker.LastErr() == nil {
-        return true, time.Now() // Capture time of acquisition
-				// Capture acquisition time with whole-second precision
-		    // return true, time.Now().Truncate(time.Second)
-
-    }
-    return false, time.Time{}
-		// Capture acquisition time with whole-second precision
-    // return false, time.Now().Truncate(time.Second)
+	locker.Lock()
+	if locker.LastErr() == nil {
+		return true, time.Now() // Capture time of acquisition
+	}
+	return false, time.Time{}
 }
 
-
-
 const (
-	leaseDuration   = 10 * time.Second
-	retryInterval   = 1 * time.Second
-	maxRetries      = 100
-	dynamoDBURL     = "http://localhost:8000"
-	lockItemID      = "lock_item_id"
-	lockTableName   = "test"
+	leaseDuration = 10 * time.Second
+	retryInterval = 1 * time.Second
+	maxRetries    = 100
+	dynamoDBURL   = "http://localhost:8000"
+	lockItemID    = "lock_item_id"
+	lockTableName = "test"
 )
 
 func setupLogger() *log.Logger {
@@ -138,9 +116,6 @@ func setupLogger() *log.Logger {
 		MinLevel: "warn",
 		Writer:   os.Stdout,
 	}
-	if enableDebug {
-		filter.MinLevel = "debug"
-	}
 	logger.SetOutput(filter)
 	return logger
 }
@@ -152,21 +127,8 @@ func acquireInitialLock(logger *log.Logger) {
 		setddblock.WithLeaseDuration(leaseDuration),
 		setddblock.WithDelay(false),
 		setddblock.WithNoPanic(),
+		setddblock.WithLogger(logger),
 	)
-	if enableDebug {
-		locker, err = setddblock.New(
-			fmt.Sprintf("ddb://%s/%s", lockTableName, lockItemID),
-			setddblock.WithEndpoint(dynamoDBURL),
-			setddblock.WithLeaseDuration(leaseDuration),
-			setddblock.WithDelay(false),
-			setddblock.WithNoPanic(),
-			setddblock.WithLogger(logger),
-		)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create locker: %v\n", err)
-			os.Exit(1)
-		}
-	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create locker: %v\n", err)
 		os.Exit(1)
@@ -174,19 +136,14 @@ func acquireInitialLock(logger *log.Logger) {
 	locker.Lock()
 	fmt.Printf("[%s] Initial lock acquired; simulating lock hold indefinitely.\n", time.Now().Format(time.RFC3339))
 
-
-
 	select {} // Keep the process alive to simulate a lock hold
 }
 
 // Test function with process forking and cleanup
 func TestTTLExpirationLock(t *testing.T) {
-
 	var retryCount int
-  var actualAcquiredTime time.Time // Declare `actualAcquiredTime` at the top
+	var actualAcquiredTime time.Time
 
-	// Use the debug variable to control logging level.
-	// This is set to false by default but can be toggled for more verbose output.
 	logger := setupLogger()
 
 	// Load AWS SDK DynamoDB client configuration
@@ -221,35 +178,32 @@ func TestTTLExpirationLock(t *testing.T) {
 	}
 	t.Logf("[%s] Forked process terminated with status: %v", time.Now().Format(time.RFC3339), processState)
 
-	// Step 4: Log initial lock's TTL and revision from DynamoDB
-	initialTTL, initialRevision, err := getItemDetails(client, lockTableName, lockItemID)
+	// Step 4: Log initial lock's lease expiry and revision from DynamoDB
+	expireTime, initialRevision, err := getItemDetails(client, lockTableName, lockItemID)
 	require.NoError(t, err, "Failed to get item details")
-	expireTime := time.Unix(initialTTL, 0)
 
-	t.Logf("[%s] Initial: REVISION=%s, TTL=%d Now=%d Expires: %s",
-		time.Now().Format(time.RFC3339), initialRevision, initialTTL, time.Now().Unix(), expireTime.Format(time.RFC3339))
+	t.Logf("[%s] Initial: REVISION=%s, Now=%s Expires: %s",
+		time.Now().Format(time.RFC3339), initialRevision, time.Now().Format(time.RFC3339Nano), expireTime.Format(time.RFC3339Nano))
 
 	// Start retry loop
 	for retryCount < maxRetries {
 		retryCount++
 		t.Logf("[%s] [Retry #%d] Attempting lock acquisition.", time.Now().Format(time.RFC3339), retryCount)
 
-    // Capture lock status and acquisition time
-    lockAcquired, acquiredTime := tryAcquireLock(t, logger, retryCount)
-
-    if lockAcquired {
-        actualAcquiredTime = acquiredTime // Capture the exact acquisition time
-        t.Logf("[%s] Lock finally acquired at %d, original TTL: %d", acquiredTime.Format(time.RFC3339), acquiredTime.Unix(), expireTime.Unix())
-        break
-    }
+		// Capture lock status and acquisition time
+		lockAcquired, acquiredTime := tryAcquireLock(t, logger)
+		if lockAcquired {
+			actualAcquiredTime = acquiredTime
+			t.Logf("[%s] Lock finally acquired, original Expires: %s", acquiredTime.Format(time.RFC3339Nano), expireTime.Format(time.RFC3339Nano))
+			break
+		}
 
-		// Check TTL to ensure it's stable and not being updated
-		currentTTL, currentRevision, err := getItemDetails(client, lockTableName, lockItemID)
+		// Check the lease expiry to ensure it's stable and not being updated
+		currentExpires, currentRevision, err := getItemDetails(client, lockTableName, lockItemID)
 		if err == nil {
-			t.Logf("[%s] [Retry #%d] REVISION=%s, TTL=%d Now=%d Expires: %s",
-				time.Now().Format(time.RFC3339), retryCount, currentRevision, currentTTL,
-				time.Now().Unix(), time.Unix(currentTTL, 0).Format(time.RFC3339))
-
+			t.Logf("[%s] [Retry #%d] REVISION=%s, Now=%s Expires: %s",
+				time.Now().Format(time.RFC3339), retryCount, currentRevision,
+				time.Now().Format(time.RFC3339Nano), currentExpires.Format(time.RFC3339Nano))
 		} else {
 			t.Logf("[Retry #%d] Failed to retrieve item details: %v", retryCount, err)
 		}
@@ -257,10 +211,9 @@ func TestTTLExpirationLock(t *testing.T) {
 		time.Sleep(retryInterval)
 	}
 
-	// Log duration between TTL expiration and successful lock acquisition
-	timeAfterTTL := actualAcquiredTime.Sub(expireTime)
-	t.Logf("[%s] Time between TTL expiration and lock acquisition: %v", time.Now().Format(time.RFC3339), timeAfterTTL)
-	require.LessOrEqual(t, timeAfterTTL.Seconds(), 3.0, "Time between TTL expiration and lock acquisition should not exceed 3 seconds")
-	require.GreaterOrEqual(t, actualAcquiredTime.Unix(), initialTTL, "Lock should only be acquired after TTL expiration")
-
+	// Log duration between lease expiration and successful lock acquisition
+	timeAfterExpires := actualAcquiredTime.Sub(expireTime)
+	t.Logf("[%s] Time between lease expiration and lock acquisition: %v", time.Now().Format(time.RFC3339), timeAfterExpires)
+	require.LessOrEqual(t, timeAfterExpires.Seconds(), 3.0, "Time between lease expiration and lock acquisition should not exceed 3 seconds")
+	require.False(t, actualAcquiredTime.Before(expireTime), "Lock should only be acquired after the lease expiration")
 }
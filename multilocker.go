@@ -0,0 +1,226 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxMultiLockItems is the maximum number of items of a DynamoDBMultiLocker, which is the item limit of a DynamoDB transaction.
+const MaxMultiLockItems = 100
+
+// DynamoDBMultiLocker acquires a set of items in the same table all-or-nothing.
+// The items are acquired, kept by heartbeats and released together in single transactions,
+// so a job holding several locks never holds only some of them and can not deadlock with another job locking the same items.
+//
+// The set is acquired and released by the embedded *DynamoDBLocker,
+// so LockWithErr, UnlockWithErr, LockContext, Lost and SetPayload work on all of the items.
+// ItemID of the embedded *DynamoDBLocker and of its leases returns the item IDs joined by commas, which is not a lock item,
+// use ItemIDs instead. FencingToken returns the token of the first item. A lease of the set can not be detached, see Lease.Detach.
+type DynamoDBMultiLocker struct {
+	*DynamoDBLocker
+	ops *multiLeaseOps
+}
+
+// NewMulti returns *DynamoDBMultiLocker for the items of the URLs. The URLs and options are the same as New.
+// All of the URLs must have the same scheme and table name.
+// The backend for the URL scheme must implement MultiLockBackend.
+func NewMulti(urlStrs []string, optFns ...func(*Options)) (*DynamoDBMultiLocker, error) {
+	if len(urlStrs) == 0 {
+		return nil, errors.New("at least one item is required")
+	}
+	var scheme, tableName string
+	itemIDs := make([]string, 0, len(urlStrs))
+	for i, urlStr := range urlStrs {
+		s, t, itemID, err := parseLockURL(urlStr)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			scheme, tableName = s, t
+		} else if s != scheme || t != tableName {
+			return nil, errors.New("all items must be in the same table")
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+	opts, err := newOptionsWith(optFns)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := openBackend(scheme, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newMulti(tableName, itemIDs, svc, opts)
+}
+
+func newMulti(tableName string, itemIDs []string, svc Backend, opts *Options) (*DynamoDBMultiLocker, error) {
+	if len(itemIDs) == 0 {
+		return nil, errors.New("at least one item is required")
+	}
+	if len(itemIDs) > MaxMultiLockItems {
+		return nil, fmt.Errorf("too many items, please set at most %d items", MaxMultiLockItems)
+	}
+	seen := make(map[string]bool, len(itemIDs))
+	for _, itemID := range itemIDs {
+		if seen[itemID] {
+			return nil, fmt.Errorf("item_id %s is duplicated", itemID)
+		}
+		seen[itemID] = true
+	}
+	multiSvc, ok := svc.(MultiLockBackend)
+	if !ok {
+		return nil, errors.New("backend does not support multi-item lock")
+	}
+	ops := &multiLeaseOps{
+		svc:     multiSvc,
+		itemIDs: append([]string(nil), itemIDs...),
+	}
+	return &DynamoDBMultiLocker{
+		DynamoDBLocker: newLocker(tableName, strings.Join(itemIDs, ","), svc, ops, opts),
+		ops:            ops,
+	}, nil
+}
+
+// ItemIDs returns the item IDs of the locks.
+func (l *DynamoDBMultiLocker) ItemIDs() []string {
+	return append([]string(nil), l.ops.itemIDs...)
+}
+
+// FencingTokens returns the fencing tokens of the last granted locks, in the order of ItemIDs.
+func (l *DynamoDBMultiLocker) FencingTokens() []int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int64(nil), l.ops.fencingTokens...)
+}
+
+// Watch returns a channel of the changes of every item, like Watch of DynamoDBLocker.
+// The item of an event is ItemID of its Details.
+func (l *DynamoDBMultiLocker) Watch(ctx context.Context) <-chan LockEvent {
+	return l.watch(ctx, l.ops.itemIDs)
+}
+
+// GetLockDetails retrieves the lock details for the items, in the order of ItemIDs.
+func (l *DynamoDBMultiLocker) GetLockDetails(ctx context.Context) ([]*LockDetails, error) {
+	details := make([]*LockDetails, 0, len(l.ops.itemIDs))
+	for _, itemID := range l.ops.itemIDs {
+		d, err := l.svc.GetLockDetails(ctx, l.tableName, itemID)
+		if err != nil {
+			return nil, fmt.Errorf("item_id %s: %w", itemID, err)
+		}
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+// multiLeaseOps runs the lease of the DynamoDBLocker on all of the items.
+// Every item of the set has the same revision while it is held.
+type multiLeaseOps struct {
+	svc     MultiLockBackend
+	itemIDs []string
+
+	// holderRevisions are the revisions of the holders seen by the last failed acquisition,
+	// which are taken over by the next acquisition if they are not extended.
+	holderRevisions []string
+	fencingTokens   []int64
+}
+
+func (ops *multiLeaseOps) inputs(parms *LockInput, prevRevisions []string) []*LockInput {
+	inputs := make([]*LockInput, 0, len(ops.itemIDs))
+	for i, itemID := range ops.itemIDs {
+		input := *parms
+		input.ItemID = itemID
+		input.PrevRevision = nil
+		if prevRevisions != nil && prevRevisions[i] != "" {
+			input.PrevRevision = &prevRevisions[i]
+		}
+		inputs = append(inputs, &input)
+	}
+	return inputs
+}
+
+func (ops *multiLeaseOps) AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	var prevRevisions []string
+	if parms.PrevRevision != nil {
+		prevRevisions = ops.holderRevisions
+	}
+	outputs, err := ops.svc.AcquireLocks(ctx, ops.inputs(parms, prevRevisions))
+	if err != nil {
+		return nil, err
+	}
+	ret := &LockOutput{
+		LockGranted:   true,
+		LeaseDuration: parms.LeaseDuration,
+		Revision:      parms.Revision,
+	}
+	for _, output := range outputs {
+		if !output.LockGranted {
+			ret.LockGranted = false
+			break
+		}
+	}
+	if !ret.LockGranted {
+		// wait until every holder seen now had to extend its lease.
+		ops.holderRevisions = make([]string, 0, len(outputs))
+		ret.LeaseDuration = 0
+		for _, output := range outputs {
+			ops.holderRevisions = append(ops.holderRevisions, output.Revision)
+			if output.NextHeartbeatLimit.After(ret.NextHeartbeatLimit) {
+				ret.NextHeartbeatLimit = output.NextHeartbeatLimit
+			}
+			if output.LeaseDuration > ret.LeaseDuration {
+				ret.LeaseDuration = output.LeaseDuration
+			}
+		}
+		ret.Revision = strings.Join(ops.holderRevisions, ",")
+		return ret, nil
+	}
+	ops.holderRevisions = nil
+	ops.fencingTokens = make([]int64, 0, len(outputs))
+	for i, output := range outputs {
+		ops.fencingTokens = append(ops.fencingTokens, output.FencingToken)
+		if i == 0 || output.NextHeartbeatLimit.Before(ret.NextHeartbeatLimit) {
+			ret.NextHeartbeatLimit = output.NextHeartbeatLimit
+		}
+	}
+	ret.FencingToken = ops.fencingTokens[0]
+	return ret, nil
+}
+
+func (ops *multiLeaseOps) SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	outputs, err := ops.svc.SendHeartbeats(ctx, ops.inputs(parms, ops.revisions(parms.PrevRevision)))
+	if err != nil {
+		return nil, err
+	}
+	ret := &LockOutput{
+		LockGranted:   true,
+		LeaseDuration: parms.LeaseDuration,
+		Revision:      parms.Revision,
+	}
+	for i, output := range outputs {
+		if i == 0 || output.NextHeartbeatLimit.Before(ret.NextHeartbeatLimit) {
+			ret.NextHeartbeatLimit = output.NextHeartbeatLimit
+		}
+	}
+	if len(ops.fencingTokens) > 0 {
+		ret.FencingToken = ops.fencingTokens[0]
+	}
+	return ret, nil
+}
+
+func (ops *multiLeaseOps) ReleaseLock(ctx context.Context, parms *LockInput) error {
+	return ops.svc.ReleaseLocks(ctx, ops.inputs(parms, ops.revisions(parms.PrevRevision)))
+}
+
+// revisions returns the revision of every item, which is the same while the set is held.
+func (ops *multiLeaseOps) revisions(revision *string) []string {
+	if revision == nil {
+		return nil
+	}
+	revisions := make([]string, len(ops.itemIDs))
+	for i := range revisions {
+		revisions[i] = *revision
+	}
+	return revisions
+}
@@ -0,0 +1,307 @@
+package setddblock_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiLocker(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	multi, err := setddblock.NewMulti(
+		[]string{"ddb://test/item1", "ddb://test/item2"},
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"item1", "item2"}, multi.ItemIDs())
+	single, err := setddblock.New(
+		"ddb://test/item2",
+		setddblock.WithBackend(backend),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	granted, err := multi.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Equal(t, []int64{1, 1}, multi.FencingTokens())
+
+	time.Sleep(200 * time.Millisecond) // heartbeats keep all of the items
+	details, err := multi.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Len(t, details, 2)
+	require.Equal(t, details[0].Revision, details[1].Revision)
+	granted, err = single.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+
+	require.NoError(t, multi.UnlockWithErr(ctx))
+	granted, err = single.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	// item2 is held, so item1 must not be acquired either.
+	multi, err = setddblock.NewMulti(
+		[]string{"ddb://test/item1", "ddb://test/item2"},
+		setddblock.WithBackend(backend),
+		setddblock.WithDelay(false),
+	)
+	require.NoError(t, err)
+	granted, err = multi.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+	_, err = single.GetLockDetails(ctx)
+	require.NoError(t, err)
+	other, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	_, err = other.GetLockDetails(ctx)
+	require.Error(t, err, "item1 is not locked")
+	require.NoError(t, single.UnlockWithErr(ctx))
+}
+
+func TestMultiLockerWaitsForAllItems(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	single, err := setddblock.New(
+		"ddb://test/item2",
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	granted, err := single.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		single.Unlock()
+	}()
+
+	multi, err := setddblock.NewMulti(
+		[]string{"ddb://test/item1", "ddb://test/item2"},
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	granted, err = multi.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	require.Equal(t, []int64{1, 2}, multi.FencingTokens())
+	require.NoError(t, multi.UnlockWithErr(ctx))
+}
+
+func TestNewMultiValidation(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	_, err := setddblock.NewMulti(nil, setddblock.WithBackend(backend))
+	require.Error(t, err)
+	_, err = setddblock.NewMulti([]string{"ddb://test/item1", "ddb://other/item2"}, setddblock.WithBackend(backend))
+	require.Error(t, err)
+	_, err = setddblock.NewMulti([]string{"ddb://test/item1", "ddb://test/item1"}, setddblock.WithBackend(backend))
+	require.Error(t, err)
+}
+
+func TestMultiLockerWatch(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	urls := []string{"ddb://test/item1", "ddb://test/item2"}
+	holder, err := setddblock.NewMulti(urls, setddblock.WithBackend(backend), setddblock.WithLeaseDuration(time.Second))
+	require.NoError(t, err)
+	lease, err := holder.Acquire(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"item1", "item2"}, lease.ItemIDs())
+
+	watcher, err := setddblock.NewMulti(urls, setddblock.WithBackend(backend), setddblock.WithWatchInterval(20*time.Millisecond))
+	require.NoError(t, err)
+	events := watcher.Watch(ctx)
+	next := func() setddblock.LockEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-ctx.Done():
+			require.FailNow(t, "no event")
+			return setddblock.LockEvent{}
+		}
+	}
+	for _, itemID := range []string{"item1", "item2"} {
+		event := next()
+		require.Equal(t, setddblock.LockEventAcquired, event.Type)
+		require.Equal(t, itemID, event.Details.ItemID, "every item is watched")
+	}
+	require.NoError(t, lease.Release(ctx))
+	released := map[string]bool{}
+	for len(released) < 2 {
+		event := next()
+		if event.Type == setddblock.LockEventReleased {
+			released[event.Details.ItemID] = true
+		}
+	}
+	require.Equal(t, map[string]bool{"item1": true, "item2": true}, released)
+}
+
+func TestMultiLockerDDBLocal(t *testing.T) {
+	endpoint := checkDDBLocalEndpoint(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	suffix := time.Now().UnixNano()
+	item1 := fmt.Sprintf("multi1-%d", suffix)
+	item2 := fmt.Sprintf("multi2-%d", suffix)
+	single, err := setddblock.New(
+		"ddb://test/"+item2,
+		setddblock.WithEndpoint(endpoint),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(500*time.Millisecond),
+	)
+	require.NoError(t, err)
+	granted, err := single.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	multi, err := setddblock.NewMulti(
+		[]string{"ddb://test/" + item1, "ddb://test/" + item2},
+		setddblock.WithEndpoint(endpoint),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(500*time.Millisecond),
+	)
+	require.NoError(t, err)
+	granted, err = multi.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "one of the items is held")
+	client, err := setddblock.NewClient(
+		setddblock.WithEndpoint(endpoint),
+		setddblock.WithOwnerName("operator"),
+	)
+	require.NoError(t, err)
+	probe, err := client.Locker("test", item1)
+	require.NoError(t, err)
+	_, err = probe.GetLockDetails(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotLocked, "no item is locked unless all of them are")
+	require.NoError(t, single.UnlockWithErr(ctx))
+
+	lease, err := multi.Acquire(ctx)
+	require.NoError(t, err)
+	details, err := multi.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.Len(t, details, 2)
+	require.Equal(t, details[0].Revision, details[1].Revision)
+
+	var fencingToken int64
+	for _, d := range details {
+		if d.ItemID == item2 {
+			fencingToken = d.FencingToken
+		}
+	}
+	require.NoError(t, client.ForceRelease(ctx, "test", item2, fencingToken, "test"))
+	select {
+	case <-lease.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("the lease of the items was not lost")
+	}
+	require.ErrorIs(t, lease.Err(), setddblock.ErrLockLost)
+}
+
+// transactStubDynamoDB reads free items, and fails the writes of the transactions with errs in order.
+type transactStubDynamoDB struct {
+	stubDynamoDB
+	errs   []error
+	writes []*dynamodb.TransactWriteItemsInput
+}
+
+func (c *transactStubDynamoDB) TransactGetItems(_ context.Context, params *dynamodb.TransactGetItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactGetItemsOutput, error) {
+	return &dynamodb.TransactGetItemsOutput{
+		Responses: make([]types.ItemResponse, len(params.TransactItems)),
+	}, nil
+}
+
+func (c *transactStubDynamoDB) TransactWriteItems(_ context.Context, params *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, params)
+	if len(c.errs) == 0 {
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return nil, err
+}
+
+func canceledBy(codes ...string) error {
+	reasons := make([]types.CancellationReason, 0, len(codes))
+	for _, code := range codes {
+		reasons = append(reasons, types.CancellationReason{Code: aws.String(code)})
+	}
+	return &types.TransactionCanceledException{
+		Message:             aws.String("Transaction cancelled"),
+		CancellationReasons: reasons,
+	}
+}
+
+func TestMultiLockerTransactionCanceled(t *testing.T) {
+	ctx := context.Background()
+	newMulti := func(client *transactStubDynamoDB) *setddblock.DynamoDBMultiLocker {
+		multi, err := setddblock.NewMulti(
+			[]string{"ddb://test/item1", "ddb://test/item2"},
+			setddblock.WithDynamoDBClient(client),
+			setddblock.WithDelay(false),
+			setddblock.WithLeaseDuration(time.Minute),
+		)
+		require.NoError(t, err)
+		return multi
+	}
+
+	client := &transactStubDynamoDB{errs: []error{canceledBy("None", "TransactionConflict")}}
+	granted, err := newMulti(client).LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted, "a conflicting transaction is retried")
+	require.Len(t, client.writes, 2)
+
+	validationErr := canceledBy("ValidationError", "None")
+	client = &transactStubDynamoDB{errs: []error{validationErr}}
+	_, err = newMulti(client).LockWithErr(ctx)
+	require.ErrorIs(t, err, validationErr, "only a failed condition is taken as a race")
+	require.Len(t, client.writes, 1)
+}
+
+func TestMultiLockerHeartbeatRetry(t *testing.T) {
+	client := &transactStubDynamoDB{}
+	multi, err := setddblock.NewMulti(
+		[]string{"ddb://test/item1", "ddb://test/item2"},
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(time.Minute),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	lease, err := multi.Acquire(ctx)
+	require.NoError(t, err)
+	client.mu.Lock()
+	client.errs = []error{canceledBy("ThrottlingError", "None")}
+	client.mu.Unlock()
+	require.NoError(t, lease.Refresh(ctx))
+
+	client.mu.Lock()
+	writes := client.writes[1:3]
+	client.mu.Unlock()
+	expires := func(write *dynamodb.TransactWriteItemsInput) int64 {
+		v, err := strconv.ParseInt(write.TransactItems[0].Update.ExpressionAttributeValues[":Expires"].(*types.AttributeValueMemberN).Value, 10, 64)
+		require.NoError(t, err)
+		return v
+	}
+	require.Greater(t, expires(writes[1]), expires(writes[0]), "a retried heartbeat extends the lease from its own time")
+	require.NoError(t, lease.Release(ctx))
+}
@@ -2,32 +2,80 @@ package setddblock
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
 )
 
 // Options are for changing the behavior of DynamoDB Locker and are changed by the function passed to the New () function.
 // See the WithXXX options for more information.
 type Options struct {
-	NoPanic       bool
-	Logger        Logger
-	Delay         bool
-	Endpoint      string
-	Region        string
-	LeaseDuration time.Duration
+	NoPanic        bool
+	Logger         Logger
+	Delay          bool
+	Endpoint       string
+	Region         string
+	AWSConfig      *aws.Config
+	DynamoDBClient DynamoDBAPI
+	Schema         *Schema
+	// AutoCreateTable creates the lock table when it does not exist. The default is true.
+	AutoCreateTable bool
+	Table           TableOptions
+	// ValidateTable checks the key schema and TTL setting of an existing lock table, see WithTableValidation.
+	ValidateTable bool
+	RepairTTL     bool
+	// ReleasedItemRetention is how long a released lock item is kept, see WithReleasedItemRetention.
+	ReleasedItemRetention time.Duration
+	LeaseDuration         time.Duration
+	// ClockSkewTolerant takes over a lock only after its revision has been seen unchanged for a full lease, see WithClockSkewTolerance.
+	ClockSkewTolerant bool
+	// WatchInterval is the polling interval of Watch and Election.Observe, see WithWatchInterval.
+	WatchInterval time.Duration
+	Backend       Backend
+	OwnerName     string
+	Payload       []byte
 	ctx           context.Context
+	payloadErr    error
+	tables        *lockTables
+	// provisioned is set by WithProvisionedThroughput, whose capacity units must be positive.
+	provisioned bool
 }
 
 // Default values
 var (
-	DefaultLeaseDuration = 10 * time.Second
+	DefaultLeaseDuration      = 10 * time.Second
+	DefaultTableActiveTimeout = 15 * time.Second
 )
 
+// TableOptions are the settings of the lock table created by the DynamoDB backend.
+// They are not applied to an existing table.
+type TableOptions struct {
+	// ReadCapacityUnits and WriteCapacityUnits switch the billing mode to PROVISIONED when they are set.
+	// The default billing mode is PAY_PER_REQUEST.
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+	Tags               map[string]string
+	// SSE enables server-side encryption with a KMS key. SSEKMSKeyID is the AWS managed key if it is empty.
+	SSE                 bool
+	SSEKMSKeyID         string
+	PointInTimeRecovery bool
+	DeletionProtection  bool
+	// ActiveTimeout is how long to wait for a created table to become active.
+	ActiveTimeout time.Duration
+}
+
 func newOptions() *Options {
 	return &Options{
-		Logger:        voidLogger{},
-		LeaseDuration: DefaultLeaseDuration,
-		Delay:         true,
-		ctx:           context.Background(),
+		Logger:          voidLogger{},
+		LeaseDuration:   DefaultLeaseDuration,
+		Delay:           true,
+		AutoCreateTable: true,
+		Table: TableOptions{
+			ActiveTimeout: DefaultTableActiveTimeout,
+		},
+		ctx: context.Background(),
 	}
 }
 
@@ -81,6 +129,101 @@ func WithRegion(region string) func(opts *Options) {
 	}
 }
 
+// WithAWSConfig specifies the aws.Config used to build the DynamoDB client instead of the default config,
+// e.g. for a custom credentials provider, assumed role, HTTP client, retryer or middleware.
+// WithRegion and WithEndpoint still override the region and endpoint of the config.
+func WithAWSConfig(cfg aws.Config) func(opts *Options) {
+	return func(opts *Options) {
+		opts.AWSConfig = &cfg
+	}
+}
+
+// WithDynamoDBClient specifies the DynamoDB client used by the DynamoDB backend, e.g. an instrumented client.
+// WithAWSConfig, WithRegion and WithEndpoint are ignored when it is specified.
+func WithDynamoDBClient(client DynamoDBAPI) func(opts *Options) {
+	return func(opts *Options) {
+		opts.DynamoDBClient = client
+	}
+}
+
+// WithSchema specifies the key schema and attribute names of the lock table, to store locks in an existing table.
+// Empty fields of the schema are the defaults of DefaultSchema.
+func WithSchema(schema Schema) func(opts *Options) {
+	return func(opts *Options) {
+		opts.Schema = &schema
+	}
+}
+
+// WithAutoCreateTable specifies whether to create the lock table when it does not exist. The default is true.
+// If false, locking fails fast with an error wrapping ErrTableNotFound.
+func WithAutoCreateTable(create bool) func(opts *Options) {
+	return func(opts *Options) {
+		opts.AutoCreateTable = create
+	}
+}
+
+// WithTableValidation checks the key schema and the TTL setting of an existing lock table before the first lock,
+// with DescribeTable and DescribeTimeToLive.
+// A mismatch is reported as *KeySchemaError or *TTLError.
+// If repairTTL is true, TTL disabled on the table is enabled on the TTL attribute instead of being reported.
+func WithTableValidation(repairTTL bool) func(opts *Options) {
+	return func(opts *Options) {
+		opts.ValidateTable = true
+		opts.RepairTTL = repairTTL
+	}
+}
+
+// WithProvisionedThroughput creates the lock table with PROVISIONED billing mode and the given capacity units instead of PAY_PER_REQUEST.
+// Both capacity units must be positive.
+func WithProvisionedThroughput(readCapacityUnits, writeCapacityUnits int64) func(opts *Options) {
+	return func(opts *Options) {
+		opts.provisioned = true
+		opts.Table.ReadCapacityUnits = readCapacityUnits
+		opts.Table.WriteCapacityUnits = writeCapacityUnits
+	}
+}
+
+// WithTableTags adds tags to the created lock table.
+func WithTableTags(tags map[string]string) func(opts *Options) {
+	return func(opts *Options) {
+		if opts.Table.Tags == nil {
+			opts.Table.Tags = make(map[string]string, len(tags))
+		}
+		for key, value := range tags {
+			opts.Table.Tags[key] = value
+		}
+	}
+}
+
+// WithTableSSE creates the lock table encrypted with the KMS key. An empty kmsKeyID means the AWS managed key.
+func WithTableSSE(kmsKeyID string) func(opts *Options) {
+	return func(opts *Options) {
+		opts.Table.SSE = true
+		opts.Table.SSEKMSKeyID = kmsKeyID
+	}
+}
+
+// WithPointInTimeRecovery enables point-in-time recovery of the created lock table.
+func WithPointInTimeRecovery() func(opts *Options) {
+	return func(opts *Options) {
+		opts.Table.PointInTimeRecovery = true
+	}
+}
+
+// WithDeletionProtection enables deletion protection of the created lock table.
+func WithDeletionProtection() func(opts *Options) {
+	return func(opts *Options) {
+		opts.Table.DeletionProtection = true
+	}
+}
+
+// WithTableActiveTimeout specifies how long to wait for a created lock table to become active. The default is 15 seconds, and it must be positive.
+func WithTableActiveTimeout(d time.Duration) func(opts *Options) {
+	return func(opts *Options) {
+		opts.Table.ActiveTimeout = d
+	}
+}
+
 // WithLeaseDuration affects the heartbeat interval and TTL after Lock acquisition. The default is 10 seconds
 func WithLeaseDuration(d time.Duration) func(opts *Options) {
 	return func(opts *Options) {
@@ -88,9 +231,79 @@ func WithLeaseDuration(d time.Duration) func(opts *Options) {
 	}
 }
 
+// WithClockSkewTolerance changes how a waiter decides that the lease of the holder has expired.
+// By default the waiter compares its wall clock with the expiry written by the holder, so a clock drift between the hosts
+// makes the lock expire too early or too late. With tolerance, the stored expiry is ignored, and the lock is taken over only
+// after the waiter has seen the same revision for a full lease duration of its own monotonic clock, like the AWS dynamodb-lock-client.
+// A waiter without delay never takes over a lock in this mode.
+// Only the exclusive locks of DynamoDBLocker and DynamoDBMultiLocker support it, since the reader and permit leases
+// of DynamoDBRWLocker and DynamoDBSemaphore expire by their stored time; NewRW and NewSemaphore return an error with it.
+func WithClockSkewTolerance(tolerant bool) func(opts *Options) {
+	return func(opts *Options) {
+		opts.ClockSkewTolerant = tolerant
+	}
+}
+
+// WithReleasedItemRetention lets the DynamoDB backend delete a released lock item d after its release, with the TTL of DynamoDB.
+// The next acquisition of a deleted item starts the fencing token over from 1, so a stale holder that outlives d
+// may hold a greater token than the new one. The default 0 keeps released items with their fencing tokens,
+// at the cost of one item for every item ID ever locked. A held lock item is never deleted either way.
+func WithReleasedItemRetention(d time.Duration) func(opts *Options) {
+	return func(opts *Options) {
+		opts.ReleasedItemRetention = d
+	}
+}
+
+// WithWatchInterval specifies how often Watch and Election.Observe read the lock item.
+// The default is half of the lease duration.
+func WithWatchInterval(d time.Duration) func(opts *Options) {
+	return func(opts *Options) {
+		opts.WatchInterval = d
+	}
+}
+
 // WithContext specifies the Context used by Lock() and Unlock().
 func WithContext(ctx context.Context) func(opts *Options) {
 	return func(opts *Options) {
 		opts.ctx = ctx
 	}
 }
+
+// WithBackend specifies the Backend used to store locks instead of the one registered for the URL scheme.
+// It is useful for sharing a backend between lockers or injecting a test double.
+func WithBackend(backend Backend) func(opts *Options) {
+	return func(opts *Options) {
+		opts.Backend = backend
+	}
+}
+
+// WithOwnerName specifies the owner name recorded on the lock item with the hostname and PID of this process.
+// GetLockDetails returns it, so that a stuck lock can be traced back to its holder.
+func WithOwnerName(name string) func(opts *Options) {
+	return func(opts *Options) {
+		opts.OwnerName = name
+	}
+}
+
+// WithPayload specifies the payload stored on the lock item while the lock is held.
+// Other clients can read it with GetLockDetails, e.g. the job ID or the address of the leader.
+// For DynamoDBRWLocker it is stored by the writer only, and NewSemaphore rejects it.
+func WithPayload(payload []byte) func(opts *Options) {
+	return func(opts *Options) {
+		opts.Payload = payload
+	}
+}
+
+// WithJSONPayload is WithPayload with the JSON encoding of v.
+// If v can not be encoded, New() returns the error.
+func WithJSONPayload(v interface{}) func(opts *Options) {
+	return func(opts *Options) {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			opts.payloadErr = fmt.Errorf("marshal payload: %w", err)
+			return
+		}
+		opts.Payload = payload
+		opts.payloadErr = nil
+	}
+}
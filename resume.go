@@ -0,0 +1,167 @@
+package setddblock
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// LeaseKind is the kind of lock that a lease holds.
+type LeaseKind string
+
+// Kinds of lease. Only a lease of LeaseKindLock, the exclusive lock of DynamoDBLocker, can be detached and resumed.
+const (
+	LeaseKindLock   LeaseKind = "lock"
+	LeaseKindRead   LeaseKind = "read"
+	LeaseKindWrite  LeaseKind = "write"
+	LeaseKindPermit LeaseKind = "permit"
+	LeaseKindMulti  LeaseKind = "multi"
+)
+
+// leaseKindOf returns the kind of lease that the locker runs on ops.
+func leaseKindOf(ops leaseOps) LeaseKind {
+	switch ops.(type) {
+	case readLeaseOps:
+		return LeaseKindRead
+	case writeLeaseOps:
+		return LeaseKindWrite
+	case permitLeaseOps:
+		return LeaseKindPermit
+	case *multiLeaseOps:
+		return LeaseKindMulti
+	default:
+		return LeaseKindLock
+	}
+}
+
+// LeaseToken is a held lease serialized by Detach, which another process can Resume.
+type LeaseToken struct {
+	Kind          LeaseKind     `json:"kind"`
+	TableName     string        `json:"table_name"`
+	ItemID        string        `json:"item_id"`
+	Revision      string        `json:"revision"`
+	LeaseDuration time.Duration `json:"lease_duration"`
+}
+
+// String returns the opaque string form of the token, which ParseLeaseToken reads.
+func (t LeaseToken) String() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseLeaseToken parses the string form of a LeaseToken.
+func ParseLeaseToken(s string) (LeaseToken, error) {
+	var t LeaseToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, fmt.Errorf("invalid lease token: %w", err)
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("invalid lease token: %w", err)
+	}
+	if t.Kind != LeaseKindLock || t.TableName == "" || t.ItemID == "" || t.Revision == "" || t.LeaseDuration <= 0 {
+		return t, fmt.Errorf("invalid lease token: %s", b)
+	}
+	return t, nil
+}
+
+// Detach stops the heartbeats without releasing the lock item, and returns the token to Resume the lease in another process,
+// e.g. before a supervised restart. The lock stays held until Expiry of the lease, so it must be resumed before then.
+// Detach returns ErrLockLost if the lease has been lost, and ErrNotLocked if it has been released or detached.
+// Only a lease of the exclusive lock of DynamoDBLocker can be detached; a lease of another kind is kept held and an error is returned.
+func (ls *Lease) Detach() (LeaseToken, error) {
+	l := ls.locker
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ls.isReleased {
+		return LeaseToken{}, ErrNotLocked
+	}
+	if kind := leaseKindOf(l.ops); kind != LeaseKindLock {
+		return LeaseToken{}, fmt.Errorf("%s lease of item_id=%s, table_name=%s can not be detached", kind, l.itemID, l.tableName)
+	}
+	ls.isReleased = true
+	if l.lease == ls {
+		l.lease = nil
+	}
+	close(ls.released)
+	<-ls.done
+	if ls.stopped {
+		select {
+		case <-ls.lost:
+			return LeaseToken{}, ErrLockLost
+		default:
+			return LeaseToken{}, ErrNotLocked
+		}
+	}
+	l.logger.Printf("[debug][setddblock] detached lease of item_id=%s, table_name=%s, revision=%s", l.itemID, l.tableName, ls.output.Revision)
+	return LeaseToken{
+		Kind:          LeaseKindLock,
+		TableName:     l.tableName,
+		ItemID:        l.itemID,
+		Revision:      ls.output.Revision,
+		LeaseDuration: ls.output.LeaseDuration,
+	}, nil
+}
+
+// Resume takes over the lease detached by Detach, possibly in another process, without releasing the lock in between.
+// It sends a heartbeat conditioned on the revision of the token, and starts the heartbeats of the returned lease until ctx is done, like Acquire.
+// If the lease has been taken by someone else or released in the meantime, or the lock item no longer exists, the error wraps ErrLockLost.
+// The owner recorded on the lock item is still the one that acquired the lock.
+// Only a token of LeaseKindLock is resumed, by a locker of the exclusive lock.
+func (l *DynamoDBLocker) Resume(ctx context.Context, token LeaseToken) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if token.Kind != LeaseKindLock {
+		return nil, fmt.Errorf("%q lease token can not be resumed", token.Kind)
+	}
+	if kind := leaseKindOf(l.ops); kind != LeaseKindLock {
+		return nil, fmt.Errorf("lease token can not be resumed by a locker of %s lease", kind)
+	}
+	if token.TableName != l.tableName || token.ItemID != l.itemID {
+		return nil, fmt.Errorf("lease token of item_id=%s, table_name=%s can not be resumed by item_id=%s, table_name=%s", token.ItemID, token.TableName, l.itemID, l.tableName)
+	}
+	if l.lease != nil {
+		return nil, ErrAlreadyLocked
+	}
+	rev, err := l.generateRevision()
+	if err != nil {
+		return nil, err
+	}
+	input := &LockInput{
+		TableName:     l.tableName,
+		ItemID:        l.itemID,
+		LeaseDuration: token.LeaseDuration,
+		Revision:      rev,
+		PrevRevision:  &token.Revision,
+		Owner:         l.owner,
+		IgnoreExpiry:  l.skewTolerant,
+		// a lease whose item has been removed is not resumed, since the fencing token would start over.
+		RequireHeld: true,
+	}
+	output, err := l.ops.SendHeartbeat(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("resume lease failed: %w", err)
+	}
+	l.logger.Printf("[debug][setddblock] resumed lease of item_id=%s, table_name=%s, revision=%s", l.itemID, l.tableName, output.Revision)
+	lease := newLease(l, input, output)
+	l.lease = lease
+	l.fencingToken = lease.fencingToken
+	l.lost = lease.lost
+	go lease.keepAlive(ctx)
+	return lease, nil
+}
+
+// Resume takes over the lease detached by Detach, see DynamoDBLocker.Resume.
+// The lease is resumed by a new locker of the item of the token.
+func (c *Client) Resume(ctx context.Context, token LeaseToken, optFns ...func(*Options)) (*Lease, error) {
+	if token.Kind != LeaseKindLock {
+		return nil, fmt.Errorf("%q lease token can not be resumed", token.Kind)
+	}
+	locker, err := c.Locker(token.TableName, token.ItemID, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return locker.Resume(ctx, token)
+}
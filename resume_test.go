@@ -0,0 +1,172 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResume(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newLocker := func(itemID string) *setddblock.DynamoDBLocker {
+		locker, err := setddblock.New(
+			"ddb://test/"+itemID,
+			setddblock.WithBackend(backend),
+			setddblock.WithDelay(false),
+			setddblock.WithLeaseDuration(200*time.Millisecond),
+		)
+		require.NoError(t, err)
+		return locker
+	}
+	locker := newLocker("item1")
+	lease, err := locker.Acquire(ctx)
+	require.NoError(t, err)
+	token, err := lease.Detach()
+	require.NoError(t, err)
+	require.Nil(t, locker.Lease())
+	_, err = lease.Detach()
+	require.ErrorIs(t, err, setddblock.ErrNotLocked)
+	require.Equal(t, setddblock.LeaseKindLock, token.Kind)
+	require.Equal(t, "item1", token.ItemID)
+	require.Equal(t, lease.Revision(), token.Revision)
+	require.Equal(t, 200*time.Millisecond, token.LeaseDuration)
+
+	parsed, err := setddblock.ParseLeaseToken(token.String())
+	require.NoError(t, err)
+	require.Equal(t, token, parsed)
+	_, err = setddblock.ParseLeaseToken("not a token")
+	require.Error(t, err)
+
+	_, err = newLocker("item1").Acquire(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotGranted, "the detached lease still holds the lock")
+	_, err = newLocker("item2").Resume(ctx, parsed)
+	require.Error(t, err)
+
+	restarted := newLocker("item1")
+	resumed, err := restarted.Resume(ctx, parsed)
+	require.NoError(t, err)
+	require.Same(t, resumed, restarted.Lease())
+	require.Equal(t, lease.FencingToken(), resumed.FencingToken(), "the resumed lease is the same acquisition")
+	require.NotEqual(t, token.Revision, resumed.Revision())
+	_, err = restarted.Resume(ctx, parsed)
+	require.ErrorIs(t, err, setddblock.ErrAlreadyLocked)
+
+	time.Sleep(500 * time.Millisecond)
+	_, err = newLocker("item1").Acquire(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotGranted, "the resumed lease is kept alive")
+	require.NoError(t, resumed.Release(ctx))
+
+	_, err = newLocker("item1").Resume(ctx, parsed)
+	require.ErrorIs(t, err, setddblock.ErrLockLost, "a token is resumed only while its revision holds the lock")
+
+	never := newLocker("item3")
+	_, err = never.Resume(ctx, setddblock.LeaseToken{
+		Kind:          setddblock.LeaseKindLock,
+		TableName:     "test",
+		ItemID:        "item3",
+		Revision:      "made-up",
+		LeaseDuration: 200 * time.Millisecond,
+	})
+	require.ErrorIs(t, err, setddblock.ErrLockLost, "a token is not resumed on an item which is not held")
+	_, err = never.GetLockDetails(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotLocked, "the item is not written by the failed resume")
+}
+
+func TestDetachMultiLease(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	multi, err := setddblock.NewMulti(
+		[]string{"ddb://test/item1", "ddb://test/item2"},
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	lease, err := multi.Acquire(ctx)
+	require.NoError(t, err)
+	_, err = lease.Detach()
+	require.Error(t, err)
+	time.Sleep(200 * time.Millisecond)
+	require.NoError(t, lease.Refresh(ctx), "the lease is kept held by the failed detach")
+
+	_, err = multi.Resume(ctx, setddblock.LeaseToken{
+		Kind:          setddblock.LeaseKindLock,
+		TableName:     "test",
+		ItemID:        multi.ItemID(),
+		Revision:      lease.Revision(),
+		LeaseDuration: 100 * time.Millisecond,
+	})
+	require.Error(t, err, "a multi locker does not resume a lease")
+
+	client, err := setddblock.NewClient(setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	token := setddblock.LeaseToken{
+		Kind:          setddblock.LeaseKindMulti,
+		TableName:     "test",
+		ItemID:        "item1,item2",
+		Revision:      lease.Revision(),
+		LeaseDuration: 100 * time.Millisecond,
+	}
+	_, err = setddblock.ParseLeaseToken(token.String())
+	require.Error(t, err)
+	_, err = client.Resume(ctx, token)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, setddblock.ErrLockLost, "the token is rejected before any heartbeat")
+	joined, err := client.Locker("test", "item1,item2")
+	require.NoError(t, err)
+	_, err = joined.GetLockDetails(ctx)
+	require.ErrorIs(t, err, setddblock.ErrNotLocked, "no lock item of the joined item IDs is written")
+	require.NoError(t, lease.Release(ctx))
+}
+
+func TestResumeRequiresHeldItem(t *testing.T) {
+	client := &conditionFailedStubDynamoDB{
+		condition: "attribute_exists(#Revision) AND #Revision=:PrevRevision",
+	}
+	locker, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+	)
+	require.NoError(t, err)
+	_, err = locker.Resume(context.Background(), setddblock.LeaseToken{
+		Kind:          setddblock.LeaseKindLock,
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "swept-revision",
+		LeaseDuration: time.Minute,
+	})
+	require.ErrorIs(t, err, setddblock.ErrLockLost)
+	require.Equal(t, []string{
+		"UpdateItem attribute_exists(#Revision) AND #Revision=:PrevRevision",
+	}, client.operations)
+	_, ok := client.names["#ID"]
+	require.False(t, ok, "every name of the expression is used")
+}
+
+// conditionFailedStubDynamoDB fails the update whose condition is the given one, as if the item had been removed.
+type conditionFailedStubDynamoDB struct {
+	stubDynamoDB
+	condition string
+	names     map[string]string
+}
+
+func (c *conditionFailedStubDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if aws.ToString(params.ConditionExpression) != c.condition {
+		return c.stubDynamoDB.UpdateItem(ctx, params, optFns...)
+	}
+	c.record("UpdateItem " + aws.ToString(params.ConditionExpression))
+	c.names = params.ExpressionAttributeNames
+	return nil, &types.ConditionalCheckFailedException{
+		Message: aws.String("The conditional request failed"),
+	}
+}
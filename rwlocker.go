@@ -0,0 +1,109 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+)
+
+// DynamoDBRWLocker provides a shared/exclusive (reader-writer) lock on a single item.
+// Many readers can hold the lock at the same time, while a writer gets exclusive access.
+// A waiting writer blocks new readers, so writers are not starved by a stream of readers.
+//
+// The write lock is the embedded *DynamoDBLocker, so Lock, Unlock, LockWithErr, LockContext and Lost work on the write side.
+// Each DynamoDBRWLocker holds at most one read lease and one write lease at a time, like DynamoDBLocker.
+// Do not mix DynamoDBRWLocker and DynamoDBLocker on the same item.
+type DynamoDBRWLocker struct {
+	*DynamoDBLocker
+	reader *DynamoDBLocker
+}
+
+// NewRW returns *DynamoDBRWLocker. The URL and options are the same as New.
+// The backend for the URL scheme must implement RWBackend.
+func NewRW(urlStr string, optFns ...func(*Options)) (*DynamoDBRWLocker, error) {
+	scheme, tableName, itemID, err := parseLockURL(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := newOptionsWith(optFns)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := openBackend(scheme, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newRW(tableName, itemID, svc, opts)
+}
+
+func newRW(tableName, itemID string, svc Backend, opts *Options) (*DynamoDBRWLocker, error) {
+	rwSvc, ok := svc.(RWBackend)
+	if !ok {
+		return nil, errors.New("backend does not support reader-writer lock")
+	}
+	if opts.ClockSkewTolerant {
+		return nil, errors.New("reader-writer lock does not support clock skew tolerance")
+	}
+	return &DynamoDBRWLocker{
+		DynamoDBLocker: newLocker(tableName, itemID, svc, writeLeaseOps{rwSvc}, opts),
+		reader:         newLocker(tableName, itemID, svc, readLeaseOps{rwSvc}, opts),
+	}, nil
+}
+
+// RLocker returns the read side of the lock.
+// The returned *DynamoDBLocker acquires and releases read leases, so it also provides LockContext and Lost for readers.
+func (l *DynamoDBRWLocker) RLocker() *DynamoDBLocker {
+	return l.reader
+}
+
+// RLockWithErr try get read lock.
+// The return value of bool indicates whether the read lock has been granted.
+func (l *DynamoDBRWLocker) RLockWithErr(ctx context.Context) (bool, error) {
+	return l.reader.LockWithErr(ctx)
+}
+
+// RUnlockWithErr releases the read lock.
+func (l *DynamoDBRWLocker) RUnlockWithErr(ctx context.Context) error {
+	return l.reader.UnlockWithErr(ctx)
+}
+
+// RLock gets the read lock like sync.RWMutex.
+func (l *DynamoDBRWLocker) RLock() {
+	l.reader.Lock()
+}
+
+// RUnlock releases the read lock like sync.RWMutex.
+func (l *DynamoDBRWLocker) RUnlock() {
+	l.reader.Unlock()
+}
+
+type readLeaseOps struct {
+	svc RWBackend
+}
+
+func (ops readLeaseOps) AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	return ops.svc.AcquireReadLock(ctx, parms)
+}
+
+func (ops readLeaseOps) SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	return ops.svc.SendReadHeartbeat(ctx, parms)
+}
+
+func (ops readLeaseOps) ReleaseLock(ctx context.Context, parms *LockInput) error {
+	return ops.svc.ReleaseReadLock(ctx, parms)
+}
+
+type writeLeaseOps struct {
+	svc RWBackend
+}
+
+func (ops writeLeaseOps) AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	return ops.svc.AcquireWriteLock(ctx, parms)
+}
+
+func (ops writeLeaseOps) SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	return ops.svc.SendHeartbeat(ctx, parms)
+}
+
+func (ops writeLeaseOps) ReleaseLock(ctx context.Context, parms *LockInput) error {
+	return ops.svc.ReleaseLock(ctx, parms)
+}
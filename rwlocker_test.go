@@ -0,0 +1,190 @@
+package setddblock_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRWLocker(t *testing.T, backend setddblock.Backend, optFns ...func(*setddblock.Options)) *setddblock.DynamoDBRWLocker {
+	t.Helper()
+	optFns = append([]func(*setddblock.Options){
+		setddblock.WithBackend(backend),
+		setddblock.WithLeaseDuration(100 * time.Millisecond),
+	}, optFns...)
+	l, err := setddblock.NewRW("ddb://test/rw", optFns...)
+	require.NoError(t, err)
+	return l
+}
+
+func TestRWLockerSharedReaders(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	readers := make([]*setddblock.DynamoDBRWLocker, 3)
+	for i := range readers {
+		readers[i] = newTestRWLocker(t, backend, setddblock.WithDelay(false))
+		granted, err := readers[i].RLockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted, "readers share the lock")
+	}
+
+	writer := newTestRWLocker(t, backend, setddblock.WithDelay(false))
+	time.Sleep(300 * time.Millisecond) // reader leases are kept by heartbeats
+	granted, err := writer.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "writer is blocked by readers")
+
+	for _, reader := range readers {
+		require.NoError(t, reader.RUnlockWithErr(ctx))
+		require.NoError(t, reader.RLocker().LastErr())
+	}
+	granted, err = writer.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	granted, err = readers[0].RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "reader is blocked by writer")
+	require.NoError(t, writer.UnlockWithErr(ctx))
+}
+
+func TestRWLockerWriterPreference(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	reader := newTestRWLocker(t, backend)
+	granted, err := reader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	writer := newTestRWLocker(t, backend)
+	writerGranted := make(chan struct{})
+	go func() {
+		defer close(writerGranted)
+		writer.Lock()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	lateReader := newTestRWLocker(t, backend, setddblock.WithDelay(false))
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "waiting writer blocks new readers")
+
+	require.NoError(t, reader.RUnlockWithErr(ctx))
+	select {
+	case <-writerGranted:
+	case <-time.After(time.Second):
+		t.Fatal("writer was not granted")
+	}
+	require.NoError(t, writer.UnlockWithErr(ctx))
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, lateReader.RUnlockWithErr(ctx))
+}
+
+func TestRWLockerExclusiveWriters(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	writer1 := newTestRWLocker(t, backend)
+	writer2 := newTestRWLocker(t, backend, setddblock.WithDelay(false))
+	granted, err := writer1.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	granted, err = writer2.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+	token := writer1.FencingToken()
+	require.NoError(t, writer1.UnlockWithErr(ctx))
+	granted, err = writer2.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.Greater(t, writer2.FencingToken(), token)
+	require.NoError(t, writer2.UnlockWithErr(ctx))
+}
+
+func TestRWLockerWriterWithoutDelay(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	reader := newTestRWLocker(t, backend)
+	granted, err := reader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	writer := newTestRWLocker(t, backend, setddblock.WithDelay(false))
+	granted, err = writer.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+	lateReader := newTestRWLocker(t, backend, setddblock.WithDelay(false))
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted, "a writer that does not wait does not block new readers")
+	require.NoError(t, lateReader.RUnlockWithErr(ctx))
+	require.NoError(t, reader.RUnlockWithErr(ctx))
+}
+
+func TestRWLockerDDBLocal(t *testing.T) {
+	endpoint := checkDDBLocalEndpoint(t)
+	ctx := context.Background()
+	urlStr := fmt.Sprintf("ddb://test/rw-%d", time.Now().UnixNano())
+	newRWLocker := func(delay bool) *setddblock.DynamoDBRWLocker {
+		l, err := setddblock.NewRW(
+			urlStr,
+			setddblock.WithEndpoint(endpoint),
+			setddblock.WithDelay(delay),
+			setddblock.WithLeaseDuration(500*time.Millisecond),
+		)
+		require.NoError(t, err)
+		return l
+	}
+	readers := []*setddblock.DynamoDBRWLocker{newRWLocker(false), newRWLocker(false)}
+	for _, reader := range readers {
+		granted, err := reader.RLockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted, "readers share the lock")
+	}
+
+	granted, err := newRWLocker(false).LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "writer is blocked by readers")
+	lateReader := newRWLocker(false)
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted, "a writer that does not wait does not block new readers")
+	require.NoError(t, lateReader.RUnlockWithErr(ctx))
+
+	writer := newRWLocker(true)
+	writerGranted := make(chan error, 1)
+	go func() {
+		_, err := writer.LockWithErr(ctx)
+		writerGranted <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "waiting writer blocks new readers")
+
+	for _, reader := range readers {
+		require.NoError(t, reader.RUnlockWithErr(ctx))
+	}
+	select {
+	case err := <-writerGranted:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer was not granted")
+	}
+	require.Greater(t, writer.FencingToken(), int64(0))
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "reader is blocked by writer")
+
+	require.NoError(t, writer.UnlockWithErr(ctx))
+	granted, err = lateReader.RLockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, lateReader.RUnlockWithErr(ctx))
+}
@@ -0,0 +1,77 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+)
+
+// DynamoDBSemaphore is a distributed counting semaphore that allows up to N concurrent holders of the same item.
+// Each holder has its own lease kept by heartbeats, and expired holders are reclaimed by the next acquisition.
+//
+// A permit is acquired and released by the embedded *DynamoDBLocker,
+// so LockWithErr, UnlockWithErr, LockContext and Lost work on the permit.
+// Each DynamoDBSemaphore holds at most one permit at a time.
+type DynamoDBSemaphore struct {
+	*DynamoDBLocker
+	permits int
+}
+
+// NewSemaphore returns *DynamoDBSemaphore with the given number of permits. The URL and options are the same as New.
+// The backend for the URL scheme must implement SemaphoreBackend.
+func NewSemaphore(urlStr string, permits int, optFns ...func(*Options)) (*DynamoDBSemaphore, error) {
+	scheme, tableName, itemID, err := parseLockURL(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := newOptionsWith(optFns)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := openBackend(scheme, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newSemaphore(tableName, itemID, permits, svc, opts)
+}
+
+func newSemaphore(tableName, itemID string, permits int, svc Backend, opts *Options) (*DynamoDBSemaphore, error) {
+	if permits < 1 {
+		return nil, errors.New("permits must be at least 1")
+	}
+	semSvc, ok := svc.(SemaphoreBackend)
+	if !ok {
+		return nil, errors.New("backend does not support semaphore")
+	}
+	if opts.ClockSkewTolerant {
+		return nil, errors.New("semaphore does not support clock skew tolerance")
+	}
+	if opts.Payload != nil {
+		return nil, errors.New("semaphore does not support payload")
+	}
+	return &DynamoDBSemaphore{
+		DynamoDBLocker: newLocker(tableName, itemID, svc, permitLeaseOps{svc: semSvc, permits: permits}, opts),
+		permits:        permits,
+	}, nil
+}
+
+// Permits returns the maximum number of concurrent holders.
+func (s *DynamoDBSemaphore) Permits() int {
+	return s.permits
+}
+
+type permitLeaseOps struct {
+	svc     SemaphoreBackend
+	permits int
+}
+
+func (ops permitLeaseOps) AcquireLock(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	return ops.svc.AcquirePermit(ctx, parms, ops.permits)
+}
+
+func (ops permitLeaseOps) SendHeartbeat(ctx context.Context, parms *LockInput) (*LockOutput, error) {
+	return ops.svc.SendPermitHeartbeat(ctx, parms)
+}
+
+func (ops permitLeaseOps) ReleaseLock(ctx context.Context, parms *LockInput) error {
+	return ops.svc.ReleasePermit(ctx, parms)
+}
@@ -0,0 +1,188 @@
+package setddblock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphorePermits(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	var mu sync.Mutex
+	var running, maxRunning int
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem, err := setddblock.NewSemaphore(
+				"ddb://test/sem",
+				2,
+				setddblock.WithBackend(backend),
+				setddblock.WithLeaseDuration(100*time.Millisecond),
+			)
+			require.NoError(t, err)
+			sem.Lock()
+			defer sem.Unlock()
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(150 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, maxRunning)
+}
+
+func TestSemaphoreReclaimExpiredHolder(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	require.NoError(t, backend.CreateLockTable(ctx, "test"))
+	// a holder that crashed without releasing its permit
+	output, err := backend.AcquirePermit(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "sem",
+		Revision:      "crashed",
+		LeaseDuration: 100 * time.Millisecond,
+	}, 1)
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+
+	sem, err := setddblock.NewSemaphore("ddb://test/sem", 1, setddblock.WithBackend(backend), setddblock.WithDelay(false))
+	require.NoError(t, err)
+	require.Equal(t, 1, sem.Permits())
+	granted, err := sem.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+
+	time.Sleep(150 * time.Millisecond)
+	granted, err = sem.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted, "expired holder is reclaimed")
+	require.NoError(t, sem.UnlockWithErr(ctx))
+
+	_, err = setddblock.NewSemaphore("ddb://test/sem", 0, setddblock.WithBackend(backend))
+	require.Error(t, err)
+}
+
+func TestSemaphoreFencingToken(t *testing.T) {
+	sem, err := setddblock.NewSemaphore(
+		"ddb://test/sem",
+		2,
+		setddblock.WithBackend(setddblocktest.NewBackend()),
+		setddblock.WithLeaseDuration(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+	var last int64
+	for i := 0; i < 3; i++ {
+		granted, err := sem.LockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted)
+		require.Greater(t, sem.FencingToken(), last, "every permit acquisition increments the fencing token")
+		last = sem.FencingToken()
+		require.NoError(t, sem.UnlockWithErr(ctx))
+	}
+}
+
+// crashingDynamoDB forwards the calls to DynamoDB until crash is called,
+// and then fails every update, as if the process had stopped.
+type crashingDynamoDB struct {
+	setddblock.DynamoDBAPI
+	crashed int32
+}
+
+func (c *crashingDynamoDB) crash() {
+	atomic.StoreInt32(&c.crashed, 1)
+}
+
+func (c *crashingDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if atomic.LoadInt32(&c.crashed) != 0 {
+		return nil, errors.New("crashed")
+	}
+	return c.DynamoDBAPI.UpdateItem(ctx, params, optFns...)
+}
+
+func TestSemaphoreDDBLocal(t *testing.T) {
+	endpoint := checkDDBLocalEndpoint(t)
+	ctx := context.Background()
+	urlStr := fmt.Sprintf("ddb://test/sem-%d", time.Now().UnixNano())
+	newSemaphore := func(permits int, optFns ...func(*setddblock.Options)) *setddblock.DynamoDBSemaphore {
+		optFns = append([]func(*setddblock.Options){
+			setddblock.WithEndpoint(endpoint),
+			setddblock.WithDelay(false),
+			setddblock.WithLeaseDuration(500 * time.Millisecond),
+		}, optFns...)
+		sem, err := setddblock.NewSemaphore(urlStr, permits, optFns...)
+		require.NoError(t, err)
+		return sem
+	}
+	holders := []*setddblock.DynamoDBSemaphore{newSemaphore(2), newSemaphore(2)}
+	var last int64
+	for _, holder := range holders {
+		granted, err := holder.LockWithErr(ctx)
+		require.NoError(t, err)
+		require.True(t, granted)
+		require.Greater(t, holder.FencingToken(), last)
+		last = holder.FencingToken()
+	}
+	waiter := newSemaphore(2)
+	granted, err := waiter.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted, "all of the permits are held")
+	require.NoError(t, holders[0].UnlockWithErr(ctx))
+	granted, err = waiter.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, holders[1].UnlockWithErr(ctx))
+	require.NoError(t, waiter.UnlockWithErr(ctx))
+
+	awsCfg, err := awsConfig.LoadDefaultConfig(ctx)
+	require.NoError(t, err)
+	client := &crashingDynamoDB{
+		DynamoDBAPI: dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
+		}),
+	}
+	crashed, err := setddblock.NewSemaphore(
+		urlStr,
+		1,
+		setddblock.WithDynamoDBClient(client),
+		setddblock.WithDelay(false),
+		setddblock.WithLeaseDuration(500*time.Millisecond),
+		setddblock.WithNoPanic(),
+	)
+	require.NoError(t, err)
+	granted, err = crashed.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	client.crash()
+
+	sem := newSemaphore(1)
+	granted, err = sem.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+	time.Sleep(time.Second)
+	granted, err = sem.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted, "expired holder is reclaimed")
+	require.Greater(t, sem.FencingToken(), last)
+	require.NoError(t, sem.UnlockWithErr(ctx))
+}
@@ -0,0 +1,225 @@
+// Package setddblocktest provides an in-memory setddblock.Backend for hermetic tests.
+//
+// The in-memory backend follows the same conditional write semantics as the DynamoDB backend,
+// so code protected by setddblock can be unit tested with plain `go test` and no DynamoDB Local.
+//
+//	backend := setddblocktest.NewBackend()
+//	locker, err := setddblock.New("ddb://lock_table/lock_item_id", setddblock.WithBackend(backend))
+package setddblocktest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mashiike/setddblock"
+)
+
+// Errors returned by Backend.
+var (
+	// ErrTableNotFound is setddblock.ErrTableNotFound, so errors.Is works the same with both backends.
+	ErrTableNotFound = setddblock.ErrTableNotFound
+)
+
+// Backend is an in-memory implementation of setddblock.Backend.
+// The zero value is not usable, use NewBackend.
+type Backend struct {
+	mu     sync.Mutex
+	tables map[string]map[string]*item
+}
+
+// item is a lock item. A released item keeps its fencing token and has an empty revision and owner.
+type item struct {
+	leaseDuration time.Duration
+	revision      string
+	expires       time.Time
+	fencingToken  int64
+	owner         setddblock.Owner
+	acquiredAt    time.Time
+	payload       []byte
+	readers       map[string]time.Time
+	holders       map[string]time.Time
+	writerWaiting time.Time
+	// broken is the record of the last ForceRelease, which broke brokenRevision.
+	broken         *setddblock.LockBrokenError
+	brokenRevision string
+}
+
+func (current *item) release() {
+	current.leaseDuration = 0
+	current.revision = ""
+	current.expires = time.Time{}
+	current.payload = nil
+	current.owner = setddblock.Owner{}
+	current.acquiredAt = time.Time{}
+}
+
+var _ setddblock.Backend = (*Backend)(nil)
+
+// NewBackend returns an empty in-memory Backend.
+func NewBackend() *Backend {
+	return &Backend{
+		tables: make(map[string]map[string]*item),
+	}
+}
+
+// LockTableExists implements setddblock.Backend.
+func (b *Backend) LockTableExists(_ context.Context, tableName string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.tables[tableName]
+	return ok, nil
+}
+
+// CreateLockTable implements setddblock.Backend.
+func (b *Backend) CreateLockTable(_ context.Context, tableName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.tables[tableName]; !ok {
+		b.tables[tableName] = make(map[string]*item)
+	}
+	return nil
+}
+
+// AcquireLock implements setddblock.Backend.
+func (b *Backend) AcquireLock(_ context.Context, parms *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return nil, err
+	}
+	current, ok := table[parms.ItemID]
+	if !ok || current.revision == "" || (parms.PrevRevision != nil && current.revision == *parms.PrevRevision) {
+		return b.put(table, parms, true), nil
+	}
+	if !parms.IgnoreExpiry && time.Now().After(current.expires) {
+		// the expired lease is taken over by this acquisition.
+		return b.put(table, parms, true), nil
+	}
+	return &setddblock.LockOutput{
+		LockGranted:        false,
+		LeaseDuration:      current.leaseDuration,
+		Revision:           current.revision,
+		NextHeartbeatLimit: time.Now().Add(current.leaseDuration).Truncate(time.Millisecond),
+	}, nil
+}
+
+// SendHeartbeat implements setddblock.Backend.
+func (b *Backend) SendHeartbeat(_ context.Context, parms *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	if parms.PrevRevision == nil {
+		return nil, errors.New("prev revision is must need")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if current, ok := table[parms.ItemID]; (ok && current.revision != *parms.PrevRevision) || (!ok && parms.RequireHeld) {
+		if ok && current.broken != nil && current.brokenRevision == *parms.PrevRevision {
+			broken := *current.broken
+			return nil, &setddblock.HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: &broken}
+		}
+		return nil, &setddblock.HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: setddblock.ErrLockLost}
+	}
+	return b.put(table, parms, false), nil
+}
+
+// ReleaseLock implements setddblock.Backend.
+func (b *Backend) ReleaseLock(_ context.Context, parms *setddblock.LockInput) error {
+	if parms.PrevRevision == nil {
+		return errors.New("prev revision is must need")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return err
+	}
+	if current, ok := table[parms.ItemID]; ok && current.revision == *parms.PrevRevision {
+		current.release()
+	}
+	return nil
+}
+
+// GetLockDetails implements setddblock.Backend.
+func (b *Backend) GetLockDetails(_ context.Context, tableName, itemID string) (*setddblock.LockDetails, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	current, ok := table[itemID]
+	if !ok || current.revision == "" {
+		return nil, fmt.Errorf("%w: item_id=%s, table_name=%s", setddblock.ErrNotLocked, itemID, tableName)
+	}
+	return current.details(itemID), nil
+}
+
+func (current *item) details(itemID string) *setddblock.LockDetails {
+	return &setddblock.LockDetails{
+		ItemID:         itemID,
+		ExpirationTime: current.expires,
+		Revision:       current.revision,
+		FencingToken:   current.fencingToken,
+		Owner:          current.owner,
+		AcquiredAt:     current.acquiredAt,
+		Payload:        current.payload,
+	}
+}
+
+// Expire moves the lease expiry of the lock item into the past, as if the holder had crashed and the lease had run out.
+// It reports whether the item exists.
+func (b *Backend) Expire(tableName, itemID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current, ok := b.tables[tableName][itemID]
+	if !ok || current.revision == "" {
+		return false
+	}
+	current.expires = time.Now().Add(-time.Millisecond)
+	return true
+}
+
+func (b *Backend) table(tableName string) (map[string]*item, error) {
+	table, ok := b.tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, tableName)
+	}
+	return table, nil
+}
+
+func (b *Backend) put(table map[string]*item, parms *setddblock.LockInput, acquire bool) *setddblock.LockOutput {
+	nextHeartbeatLimit := time.Now().Add(parms.LeaseDuration)
+	current, ok := table[parms.ItemID]
+	if !ok {
+		current = &item{}
+		table[parms.ItemID] = current
+	}
+	if acquire {
+		current.fencingToken++
+		current.owner = parms.Owner
+		current.acquiredAt = time.Now()
+		current.payload = nil
+	}
+	if parms.ClearPayload && !acquire {
+		current.payload = nil
+	}
+	if parms.Payload != nil {
+		current.payload = append([]byte(nil), parms.Payload...)
+	}
+	current.leaseDuration = parms.LeaseDuration
+	current.revision = parms.Revision
+	current.expires = nextHeartbeatLimit.Truncate(time.Millisecond)
+	return &setddblock.LockOutput{
+		LockGranted:        true,
+		LeaseDuration:      parms.LeaseDuration,
+		NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+		Revision:           parms.Revision,
+		FencingToken:       current.fencingToken,
+	}
+}
@@ -0,0 +1,191 @@
+package setddblocktest_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendMutualExclusion(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	var wg sync.WaitGroup
+	var total int
+	workerNum := 5
+	countMax := 10
+	for i := 0; i < workerNum; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locker, err := setddblock.New(
+				"ddb://test/item1",
+				setddblock.WithBackend(backend),
+				setddblock.WithLeaseDuration(100*time.Millisecond),
+			)
+			require.NoError(t, err)
+			locker.Lock()
+			defer locker.Unlock()
+			for j := 0; j < countMax; j++ {
+				current := total
+				time.Sleep(time.Millisecond)
+				total = current + 1
+			}
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, workerNum*countMax, total)
+}
+
+func TestBackendNoDelay(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker1, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend))
+	require.NoError(t, err)
+	locker2, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend), setddblock.WithDelay(false))
+	require.NoError(t, err)
+
+	granted, err := locker1.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+
+	granted, err = locker2.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.False(t, granted)
+
+	details, err := locker2.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, details.Revision)
+
+	require.NoError(t, locker1.UnlockWithErr(ctx))
+	granted, err = locker2.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	require.NoError(t, locker2.UnlockWithErr(ctx))
+}
+
+func TestBackendHeartbeatKeepsLease(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	locker1, err := setddblock.New("ddb://test/item1", setddblock.WithBackend(backend), setddblock.WithLeaseDuration(100*time.Millisecond))
+	require.NoError(t, err)
+	granted, err := locker1.LockWithErr(ctx)
+	require.NoError(t, err)
+	require.True(t, granted)
+	before, err := locker1.GetLockDetails(ctx)
+	require.NoError(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+	after, err := locker1.GetLockDetails(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, before.Revision, after.Revision, "heartbeat rotates the revision")
+	require.NoError(t, locker1.UnlockWithErr(ctx))
+	require.NoError(t, locker1.LastErr())
+
+	_, err = locker1.GetLockDetails(ctx)
+	require.Error(t, err, "released lock has no holder")
+}
+
+func TestBackendExpire(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	require.NoError(t, backend.CreateLockTable(ctx, "test"))
+	output, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev1",
+		LeaseDuration: time.Minute,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+
+	output, err = backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev2",
+		LeaseDuration: time.Minute,
+	})
+	require.NoError(t, err)
+	require.False(t, output.LockGranted)
+	require.Equal(t, "rev1", output.Revision)
+
+	require.True(t, backend.Expire("test", "item1"))
+	output, err = backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev2",
+		LeaseDuration: time.Minute,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+	require.Equal(t, "rev2", output.Revision, "the expired lock is taken over by the new revision")
+	require.EqualValues(t, 2, output.FencingToken)
+
+	output, err = backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev3",
+		LeaseDuration: time.Minute,
+	})
+	require.NoError(t, err)
+	require.False(t, output.LockGranted, "only one contender takes over the expired lock")
+	require.Equal(t, "rev2", output.Revision)
+
+	prev := "rev0"
+	_, err = backend.SendHeartbeat(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev3",
+		PrevRevision:  &prev,
+		LeaseDuration: time.Minute,
+	})
+	require.ErrorIs(t, err, setddblock.ErrLockLost)
+}
+
+func TestBackendIgnoreExpiry(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx := context.Background()
+	require.NoError(t, backend.CreateLockTable(ctx, "test"))
+	output, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev1",
+		LeaseDuration: time.Minute,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+
+	require.True(t, backend.Expire("test", "item1"))
+	output, err = backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev2",
+		LeaseDuration: time.Minute,
+		IgnoreExpiry:  true,
+	})
+	require.NoError(t, err)
+	require.False(t, output.LockGranted, "the stored expiry is not trusted")
+	require.Equal(t, "rev1", output.Revision)
+
+	output, err = backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "rev2",
+		PrevRevision:  &output.Revision,
+		LeaseDuration: time.Minute,
+		IgnoreExpiry:  true,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted, "the observed revision is taken over")
+	require.Equal(t, "rev2", output.Revision)
+}
+
+func TestBackendTableNotFound(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	_, err := backend.GetLockDetails(context.Background(), "test", "item1")
+	require.ErrorIs(t, err, setddblocktest.ErrTableNotFound)
+	require.ErrorIs(t, err, setddblock.ErrTableNotFound, "the same sentinel as the DynamoDB backend")
+}
@@ -0,0 +1,36 @@
+package setddblocktest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mashiike/setddblock"
+)
+
+var _ setddblock.Breaker = (*Backend)(nil)
+
+// ForceRelease implements setddblock.Breaker.
+func (b *Backend) ForceRelease(_ context.Context, parms *setddblock.ForceReleaseInput) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return err
+	}
+	current, ok := table[parms.ItemID]
+	if !ok || current.revision == "" {
+		return fmt.Errorf("%w: item_id=%s, table_name=%s", setddblock.ErrNotLocked, parms.ItemID, parms.TableName)
+	}
+	if current.fencingToken != parms.FencingToken {
+		return fmt.Errorf("%w: item_id=%s, table_name=%s, fencing_token=%d, expected_fencing_token=%d", setddblock.ErrFencingTokenMismatch, parms.ItemID, parms.TableName, current.fencingToken, parms.FencingToken)
+	}
+	current.broken = &setddblock.LockBrokenError{
+		BrokenBy: parms.BrokenBy,
+		BrokenAt: time.Now(),
+		Reason:   parms.Reason,
+	}
+	current.brokenRevision = current.revision
+	current.release()
+	return nil
+}
@@ -0,0 +1,86 @@
+package setddblocktest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mashiike/setddblock"
+)
+
+// holderMap selects a map of shared lock holder leases of the item, creating it if needed.
+type holderMap func(current *item) map[string]time.Time
+
+func readers(current *item) map[string]time.Time {
+	if current.readers == nil {
+		current.readers = make(map[string]time.Time)
+	}
+	return current.readers
+}
+
+func holders(current *item) map[string]time.Time {
+	if current.holders == nil {
+		current.holders = make(map[string]time.Time)
+	}
+	return current.holders
+}
+
+func (b *Backend) sendHolderHeartbeat(parms *setddblock.LockInput, leases holderMap) (*setddblock.LockOutput, error) {
+	if parms.PrevRevision == nil {
+		return nil, errors.New("prev revision is must need")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return nil, err
+	}
+	current, ok := table[parms.ItemID]
+	if !ok {
+		return nil, &setddblock.HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: setddblock.ErrLockLost}
+	}
+	if _, ok := leases(current)[*parms.PrevRevision]; !ok {
+		return nil, &setddblock.HeartbeatError{TableName: parms.TableName, ItemID: parms.ItemID, Err: setddblock.ErrLockLost}
+	}
+	delete(leases(current), *parms.PrevRevision)
+	nextHeartbeatLimit := time.Now().Add(parms.LeaseDuration)
+	leases(current)[parms.Revision] = nextHeartbeatLimit
+	return &setddblock.LockOutput{
+		LockGranted:        true,
+		LeaseDuration:      parms.LeaseDuration,
+		NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+		Revision:           parms.Revision,
+		FencingToken:       current.fencingToken,
+	}, nil
+}
+
+func (b *Backend) releaseHolder(parms *setddblock.LockInput, leases holderMap) error {
+	if parms.PrevRevision == nil {
+		return errors.New("prev revision is must need")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return err
+	}
+	if current, ok := table[parms.ItemID]; ok {
+		delete(leases(current), *parms.PrevRevision)
+	}
+	return nil
+}
+
+// reclaimHolders removes the expired leases and returns the number of live leases and the earliest expiration among them.
+func reclaimHolders(leases map[string]time.Time, now time.Time, waitUntil time.Time) (int, time.Time) {
+	live := 0
+	for revision, expires := range leases {
+		if expires.Before(now) {
+			delete(leases, revision)
+			continue
+		}
+		live++
+		if expires.Before(waitUntil) {
+			waitUntil = expires
+		}
+	}
+	return live, waitUntil
+}
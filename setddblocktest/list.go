@@ -0,0 +1,44 @@
+package setddblocktest
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/mashiike/setddblock"
+)
+
+var _ setddblock.Lister = (*Backend)(nil)
+
+// ListLocks implements setddblock.Lister. The items are read in the order of their IDs,
+// and the page token is the ID of the last item read.
+func (b *Backend) ListLocks(_ context.Context, tableName string, filter setddblock.LockFilter) (*setddblock.LockPage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	itemIDs := make([]string, 0, len(table))
+	for itemID := range table {
+		if itemID > filter.PageToken {
+			itemIDs = append(itemIDs, itemID)
+		}
+	}
+	sort.Strings(itemIDs)
+	page := &setddblock.LockPage{
+		Locks: []*setddblock.LockDetails{},
+	}
+	if filter.Limit > 0 && len(itemIDs) > filter.Limit {
+		itemIDs = itemIDs[:filter.Limit]
+		page.NextPageToken = itemIDs[len(itemIDs)-1]
+	}
+	for _, itemID := range itemIDs {
+		current := table[itemID]
+		if current.revision == "" || !strings.HasPrefix(itemID, filter.ItemIDPrefix) {
+			continue
+		}
+		page.Locks = append(page.Locks, current.details(itemID))
+	}
+	return page, nil
+}
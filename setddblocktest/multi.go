@@ -0,0 +1,89 @@
+package setddblocktest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mashiike/setddblock"
+)
+
+var _ setddblock.MultiLockBackend = (*Backend)(nil)
+
+// AcquireLocks implements setddblock.MultiLockBackend.
+func (b *Backend) AcquireLocks(_ context.Context, parms []*setddblock.LockInput) ([]*setddblock.LockOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	held := false
+	current := make([]*setddblock.LockOutput, 0, len(parms))
+	for _, p := range parms {
+		table, err := b.table(p.TableName)
+		if err != nil {
+			return nil, err
+		}
+		output := &setddblock.LockOutput{
+			LockGranted:        false,
+			NextHeartbeatLimit: now.Truncate(time.Millisecond),
+		}
+		if it, ok := table[p.ItemID]; ok && it.revision != "" {
+			output.LeaseDuration = it.leaseDuration
+			output.Revision = it.revision
+			output.NextHeartbeatLimit = now.Add(it.leaseDuration).Truncate(time.Millisecond)
+			if (p.IgnoreExpiry || !now.After(it.expires)) && (p.PrevRevision == nil || *p.PrevRevision != it.revision) {
+				held = true
+			}
+		}
+		current = append(current, output)
+	}
+	if held {
+		return current, nil
+	}
+	outputs := make([]*setddblock.LockOutput, 0, len(parms))
+	for _, p := range parms {
+		outputs = append(outputs, b.put(b.tables[p.TableName], p, true))
+	}
+	return outputs, nil
+}
+
+// SendHeartbeats implements setddblock.MultiLockBackend.
+func (b *Backend) SendHeartbeats(_ context.Context, parms []*setddblock.LockInput) ([]*setddblock.LockOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range parms {
+		if p.PrevRevision == nil {
+			return nil, errors.New("prev revision is must need")
+		}
+		table, err := b.table(p.TableName)
+		if err != nil {
+			return nil, err
+		}
+		if it, ok := table[p.ItemID]; !ok || it.revision != *p.PrevRevision {
+			return nil, &setddblock.HeartbeatError{TableName: p.TableName, ItemID: p.ItemID, Err: setddblock.ErrLockLost}
+		}
+	}
+	outputs := make([]*setddblock.LockOutput, 0, len(parms))
+	for _, p := range parms {
+		outputs = append(outputs, b.put(b.tables[p.TableName], p, false))
+	}
+	return outputs, nil
+}
+
+// ReleaseLocks implements setddblock.MultiLockBackend.
+func (b *Backend) ReleaseLocks(_ context.Context, parms []*setddblock.LockInput) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range parms {
+		if p.PrevRevision == nil {
+			return errors.New("prev revision is must need")
+		}
+		table, err := b.table(p.TableName)
+		if err != nil {
+			return err
+		}
+		if it, ok := table[p.ItemID]; ok && it.revision == *p.PrevRevision {
+			it.release()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package setddblocktest
+
+import (
+	"context"
+	"time"
+
+	"github.com/mashiike/setddblock"
+)
+
+var _ setddblock.RWBackend = (*Backend)(nil)
+
+// AcquireReadLock implements setddblock.RWBackend.
+func (b *Backend) AcquireReadLock(_ context.Context, parms *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	current, ok := table[parms.ItemID]
+	if !ok {
+		current = &item{}
+		table[parms.ItemID] = current
+	}
+	writerGone := current.revision == "" || (!parms.IgnoreExpiry && now.After(current.expires)) ||
+		(parms.PrevRevision != nil && current.revision == *parms.PrevRevision)
+	if writerGone && current.writerWaiting.Before(now) {
+		current.release()
+		nextHeartbeatLimit := now.Add(parms.LeaseDuration)
+		readers(current)[parms.Revision] = nextHeartbeatLimit
+		return &setddblock.LockOutput{
+			LockGranted:        true,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+			Revision:           parms.Revision,
+			FencingToken:       current.fencingToken,
+		}, nil
+	}
+	leaseDuration := parms.LeaseDuration
+	if current.revision != "" {
+		leaseDuration = current.leaseDuration
+	}
+	return &setddblock.LockOutput{
+		LockGranted:        false,
+		LeaseDuration:      leaseDuration,
+		Revision:           current.revision,
+		NextHeartbeatLimit: now.Add(leaseDuration).Truncate(time.Millisecond),
+		FencingToken:       current.fencingToken,
+	}, nil
+}
+
+// SendReadHeartbeat implements setddblock.RWBackend.
+func (b *Backend) SendReadHeartbeat(_ context.Context, parms *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	return b.sendHolderHeartbeat(parms, readers)
+}
+
+// ReleaseReadLock implements setddblock.RWBackend.
+func (b *Backend) ReleaseReadLock(_ context.Context, parms *setddblock.LockInput) error {
+	return b.releaseHolder(parms, readers)
+}
+
+// AcquireWriteLock implements setddblock.RWBackend.
+func (b *Backend) AcquireWriteLock(_ context.Context, parms *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	current, ok := table[parms.ItemID]
+	if !ok {
+		return b.put(table, parms, true), nil
+	}
+	if current.revision != "" && (parms.IgnoreExpiry || !now.After(current.expires)) &&
+		(parms.PrevRevision == nil || *parms.PrevRevision != current.revision) {
+		if parms.Wait {
+			current.writerWaiting = now.Add(current.leaseDuration).Add(parms.LeaseDuration)
+		}
+		return &setddblock.LockOutput{
+			LockGranted:        false,
+			LeaseDuration:      current.leaseDuration,
+			Revision:           current.revision,
+			NextHeartbeatLimit: now.Add(current.leaseDuration).Truncate(time.Millisecond),
+			FencingToken:       current.fencingToken,
+		}, nil
+	}
+	live, waitUntil := reclaimHolders(readers(current), now, now.Add(parms.LeaseDuration))
+	if live > 0 {
+		if parms.Wait {
+			current.writerWaiting = waitUntil.Add(parms.LeaseDuration)
+		}
+		return &setddblock.LockOutput{
+			LockGranted:        false,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: waitUntil.Truncate(time.Millisecond),
+			FencingToken:       current.fencingToken,
+		}, nil
+	}
+	current.writerWaiting = time.Time{}
+	return b.put(table, parms, true), nil
+}
@@ -0,0 +1,54 @@
+package setddblocktest
+
+import (
+	"context"
+	"time"
+
+	"github.com/mashiike/setddblock"
+)
+
+var _ setddblock.SemaphoreBackend = (*Backend)(nil)
+
+// AcquirePermit implements setddblock.SemaphoreBackend.
+func (b *Backend) AcquirePermit(_ context.Context, parms *setddblock.LockInput, permits int) (*setddblock.LockOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, err := b.table(parms.TableName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	current, ok := table[parms.ItemID]
+	if !ok {
+		current = &item{}
+		table[parms.ItemID] = current
+	}
+	live, waitUntil := reclaimHolders(holders(current), now, now.Add(parms.LeaseDuration))
+	if live >= permits {
+		return &setddblock.LockOutput{
+			LockGranted:        false,
+			LeaseDuration:      parms.LeaseDuration,
+			NextHeartbeatLimit: waitUntil.Truncate(time.Millisecond),
+		}, nil
+	}
+	nextHeartbeatLimit := now.Add(parms.LeaseDuration)
+	holders(current)[parms.Revision] = nextHeartbeatLimit
+	current.fencingToken++
+	return &setddblock.LockOutput{
+		LockGranted:        true,
+		LeaseDuration:      parms.LeaseDuration,
+		NextHeartbeatLimit: nextHeartbeatLimit.Truncate(time.Millisecond),
+		Revision:           parms.Revision,
+		FencingToken:       current.fencingToken,
+	}, nil
+}
+
+// SendPermitHeartbeat implements setddblock.SemaphoreBackend.
+func (b *Backend) SendPermitHeartbeat(_ context.Context, parms *setddblock.LockInput) (*setddblock.LockOutput, error) {
+	return b.sendHolderHeartbeat(parms, holders)
+}
+
+// ReleasePermit implements setddblock.SemaphoreBackend.
+func (b *Backend) ReleasePermit(_ context.Context, parms *setddblock.LockInput) error {
+	return b.releaseHolder(parms, holders)
+}
@@ -0,0 +1,106 @@
+package setddblock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LockEventType is the kind of a change of the lock item observed by Watch.
+type LockEventType string
+
+// Lock event types
+const (
+	// LockEventAcquired is sent when the lock is acquired, by a new acquisition or by a takeover.
+	LockEventAcquired LockEventType = "acquired"
+	// LockEventHeartbeat is sent when the holder extends its lease.
+	LockEventHeartbeat LockEventType = "heartbeat"
+	// LockEventReleased is sent when the lock is released.
+	LockEventReleased LockEventType = "released"
+	// LockEventExpired is sent when the lease of the holder has run out without a heartbeat.
+	LockEventExpired LockEventType = "expired"
+)
+
+// LockEvent is a change of the lock item observed by Watch.
+type LockEvent struct {
+	Type LockEventType
+	// Details is the state of the lock item. For LockEventReleased, it is the last state seen before the release.
+	Details *LockDetails
+	// Time is the local time when the change was observed.
+	Time time.Time
+}
+
+// Watch returns a channel of the changes of the lock item, without taking the lock.
+// The lock item is read with GetLockDetails every watch interval, see WithWatchInterval,
+// so changes between two reads are merged, e.g. a release followed by an acquisition is sent as LockEventAcquired.
+// If the lock is held when Watch starts, the first event is LockEventAcquired of the current holder.
+// The channel is closed when ctx is done.
+func (l *DynamoDBLocker) Watch(ctx context.Context) <-chan LockEvent {
+	return l.watch(ctx, []string{l.itemID})
+}
+
+// watch polls the lock items every watch interval, and sends the events of each item.
+func (l *DynamoDBLocker) watch(ctx context.Context, itemIDs []string) <-chan LockEvent {
+	ch := make(chan LockEvent)
+	go func() {
+		defer close(ch)
+		watchers := make([]lockWatcher, len(itemIDs))
+		for {
+			for i, itemID := range itemIDs {
+				details, err := l.svc.GetLockDetails(ctx, l.tableName, itemID)
+				if errors.Is(err, ErrNotLocked) {
+					details, err = nil, nil
+				}
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					l.logger.Printf("[warn][setddblock] watch item_id=%s, table_name=%s failed: %s", itemID, l.tableName, err)
+					continue
+				}
+				for _, event := range watchers[i].next(details, time.Now()) {
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- event:
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.watchInterval):
+			}
+		}
+	}()
+	return ch
+}
+
+// lockWatcher turns the successive states of the lock item into events.
+type lockWatcher struct {
+	last    *LockDetails
+	expired bool
+}
+
+// next returns the events between the last state and details, which is nil if the lock is not held.
+func (w *lockWatcher) next(details *LockDetails, now time.Time) []LockEvent {
+	var events []LockEvent
+	switch {
+	case details == nil:
+		if w.last != nil {
+			events = append(events, LockEvent{Type: LockEventReleased, Details: w.last, Time: now})
+		}
+	case w.last == nil || w.last.FencingToken != details.FencingToken:
+		events = append(events, LockEvent{Type: LockEventAcquired, Details: details, Time: now})
+		w.expired = false
+	case w.last.Revision != details.Revision:
+		events = append(events, LockEvent{Type: LockEventHeartbeat, Details: details, Time: now})
+		w.expired = false
+	}
+	if details != nil && !w.expired && now.After(details.ExpirationTime) {
+		events = append(events, LockEvent{Type: LockEventExpired, Details: details, Time: now})
+		w.expired = true
+	}
+	w.last = details
+	return events
+}
@@ -0,0 +1,93 @@
+package setddblock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mashiike/setddblock"
+	"github.com/mashiike/setddblock/setddblocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func nextLockEvent(t *testing.T, events <-chan setddblock.LockEvent) setddblock.LockEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "watch channel closed")
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no lock event")
+	}
+	return setddblock.LockEvent{}
+}
+
+func TestWatch(t *testing.T) {
+	backend := setddblocktest.NewBackend()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, backend.CreateLockTable(ctx, "test"))
+	watcher, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithWatchInterval(20*time.Millisecond),
+	)
+	require.NoError(t, err)
+	events := watcher.Watch(ctx)
+
+	holder, err := setddblock.New(
+		"ddb://test/item1",
+		setddblock.WithBackend(backend),
+		setddblock.WithOwnerName("holder"),
+		setddblock.WithLeaseDuration(200*time.Millisecond),
+	)
+	require.NoError(t, err)
+	lease, err := holder.Acquire(ctx)
+	require.NoError(t, err)
+	event := nextLockEvent(t, events)
+	require.Equal(t, setddblock.LockEventAcquired, event.Type)
+	require.Equal(t, "holder", event.Details.Owner.Name)
+	require.Equal(t, lease.FencingToken(), event.Details.FencingToken)
+
+	require.NoError(t, lease.Refresh(ctx))
+	event = nextLockEvent(t, events)
+	require.Equal(t, setddblock.LockEventHeartbeat, event.Type)
+	require.Equal(t, lease.Revision(), event.Details.Revision)
+
+	require.NoError(t, lease.Release(ctx))
+	event = nextLockEvent(t, events)
+	for event.Type == setddblock.LockEventHeartbeat {
+		// a heartbeat of the background may be seen before the release.
+		event = nextLockEvent(t, events)
+	}
+	require.Equal(t, setddblock.LockEventReleased, event.Type)
+	require.Equal(t, "holder", event.Details.Owner.Name)
+
+	// a holder which crashes without releasing the lock.
+	output, err := backend.AcquireLock(ctx, &setddblock.LockInput{
+		TableName:     "test",
+		ItemID:        "item1",
+		Revision:      "crashed-holder",
+		LeaseDuration: time.Minute,
+	})
+	require.NoError(t, err)
+	require.True(t, output.LockGranted)
+	event = nextLockEvent(t, events)
+	require.Equal(t, setddblock.LockEventAcquired, event.Type)
+	require.Equal(t, "crashed-holder", event.Details.Revision)
+	require.True(t, backend.Expire("test", "item1"))
+	event = nextLockEvent(t, events)
+	require.Equal(t, setddblock.LockEventExpired, event.Type)
+	require.Equal(t, "crashed-holder", event.Details.Revision)
+
+	lease, err = holder.Acquire(ctx)
+	require.NoError(t, err)
+	defer lease.Release(ctx)
+	event = nextLockEvent(t, events)
+	require.Equal(t, setddblock.LockEventAcquired, event.Type, "the expired lock is taken over")
+	require.Equal(t, lease.FencingToken(), event.Details.FencingToken)
+
+	cancel()
+	for range events {
+	}
+}